@@ -0,0 +1,59 @@
+// Command docker-credential-trebuchet implements the Docker credential helper protocol
+// (https://github.com/docker/docker-credential-helpers) against ECR, so Docker resolves
+// `docker pull`/`docker push` credentials through trebuchet's --role/--profile/SSO
+// resolution instead of requiring a separate `aws ecr get-login-password` step.
+//
+// Docker invokes credential helpers as `docker-credential-trebuchet <verb>` with the
+// request body on stdin, so there is no way to pass the usual --role/--profile/--sso-profile
+// flags; configure them via the TREBUCHET_ROLE / TREBUCHET_PROFILE / TREBUCHET_SSO_PROFILE /
+// TREBUCHET_MFA_SERIAL / TREBUCHET_SESSION_DURATION environment variables instead.
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hylandsoftware/trebuchet/internal/cliopts"
+	"github.com/hylandsoftware/trebuchet/internal/credhelper"
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: docker-credential-trebuchet <get|store|erase|list>")
+		os.Exit(1)
+	}
+
+	roleOpts := ecr.RoleOptions{SessionDuration: sessionDurationFromEnv()}
+	if mfaSerial := os.Getenv("TREBUCHET_MFA_SERIAL"); mfaSerial != "" {
+		roleOpts.MFASerial = mfaSerial
+		roleOpts.MFATokenProvider = cliopts.PromptForMFAToken
+	}
+
+	helper := &credhelper.Helper{
+		NewClient: ecr.NewClient,
+		Role:      os.Getenv("TREBUCHET_ROLE"),
+		Profile:   cliopts.ResolveProfile(os.Getenv("TREBUCHET_PROFILE"), os.Getenv("TREBUCHET_SSO_PROFILE")),
+		RoleOpts:  roleOpts,
+	}
+
+	if err := helper.Serve(os.Args[1], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func sessionDurationFromEnv() time.Duration {
+	raw := os.Getenv("TREBUCHET_SESSION_DURATION")
+	if raw == "" {
+		return time.Hour
+	}
+
+	duration, err := time.ParseDuration(raw)
+	if err != nil {
+		return time.Hour
+	}
+
+	return duration
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/spf13/cobra"
+)
+
+var (
+	additionalRegistries []string
+	pushTo               string
+)
+
+// pushHostPattern matches an ECR registry hostname and captures its account ID, so --to can
+// be given as the URL docker/ECR actually use rather than a bare account ID.
+var pushHostPattern = regexp.MustCompile(`^(\d+)\.dkr\.ecr\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// pushCmd resolves ECR login credentials for the caller's own account plus any
+// --additional-registry / --to targets, so a single invocation can authenticate against
+// several cross-account ECR registries (e.g. dev/stage/prod) without re-running the
+// assume-role dance per target. It prints one JSON RegistryAuth per line to stdout for the
+// caller to feed into `docker login`; it does not invoke `docker push` itself.
+var pushCmd = &cobra.Command{
+	Use:   "push <repository>",
+	Short: "Resolve ECR login credentials for one or more registries",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runPush,
+}
+
+func init() {
+	pushCmd.Flags().StringArrayVar(&additionalRegistries, "additional-registry", nil, "registry ID (AWS account) of an additional ECR registry to authenticate against, alongside the caller's own account (repeatable)")
+	pushCmd.Flags().StringVar(&pushTo, "to", "", "registry URL (<account>.dkr.ecr.<region>.amazonaws.com) of an additional ECR registry to authenticate against, alongside the caller's own account")
+	RootCmd.AddCommand(pushCmd)
+}
+
+func runPush(cmd *cobra.Command, args []string) error {
+	registryIDs, err := buildRegistryIDs(additionalRegistries, pushTo)
+	if err != nil {
+		return err
+	}
+
+	client, err := newECRClient()
+	if err != nil {
+		return err
+	}
+
+	return runPushWithClient(client, args[0], registryIDs, cmd.OutOrStdout(), os.Stderr)
+}
+
+// buildRegistryIDs merges --additional-registry with the account ID extracted from --to (if
+// given) into the registryIDs slice passed to GetAuthorizationTokensFor.
+func buildRegistryIDs(additionalRegistries []string, to string) ([]string, error) {
+	registryIDs := append([]string{}, additionalRegistries...)
+	if to == "" {
+		return registryIDs, nil
+	}
+
+	registryID, err := registryIDFromHost(to)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(registryIDs, registryID), nil
+}
+
+// runPushWithClient contains runPush's control flow with client/output as seams, so tests can
+// exercise it against a fake ECRClient without talking to AWS. It resolves the repository and
+// the caller's own-account credentials plus any cross-account registryIDs, then emits one JSON
+// RegistryAuth per line to out.
+func runPushWithClient(client ecr.ECRClient, repository string, registryIDs []string, out, errOut io.Writer) error {
+	uri, err := ecr.SetupRepository(client, repository, ecr.RepositoryConfig{})
+	if err != nil {
+		return err
+	}
+
+	auths, err := client.GetAuthorizationTokensFor(registryIDs)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(out)
+	for _, auth := range auths {
+		if err := encoder.Encode(auth); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(errOut, "resolved repository %s\n", uri)
+	return nil
+}
+
+// registryIDFromHost extracts the account ID from an ECR registry hostname given to --to.
+func registryIDFromHost(host string) (string, error) {
+	match := pushHostPattern.FindStringSubmatch(host)
+	if match == nil {
+		return "", fmt.Errorf("--to: %q is not an ECR registry hostname", host)
+	}
+
+	return match[1], nil
+}
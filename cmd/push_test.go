@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeECRClient is a minimal ecr.ECRClient stand-in for exercising runPushWithClient without
+// talking to AWS; only the methods the push pipeline actually calls do anything interesting.
+type fakeECRClient struct {
+	uri   string
+	auths []ecr.RegistryAuth
+	err   error
+
+	gotRegistryIDs []string
+}
+
+func (f *fakeECRClient) RepositoryExists(repository string) (bool, error) { return true, nil }
+func (f *fakeECRClient) CreateRepository(repository string, config ecr.RepositoryConfig) error {
+	return nil
+}
+func (f *fakeECRClient) GetRepositoryURI(repository string) (string, error) { return f.uri, nil }
+func (f *fakeECRClient) GetAuthorizationToken() (*ecr.RegistryAuth, error)  { return nil, nil }
+
+func (f *fakeECRClient) GetAuthorizationTokensFor(registryIDs []string) ([]ecr.RegistryAuth, error) {
+	f.gotRegistryIDs = registryIDs
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.auths, nil
+}
+
+func (f *fakeECRClient) GetRepositoryConfig(repository string) (*ecr.RepositoryConfig, error) {
+	return &ecr.RepositoryConfig{}, nil
+}
+func (f *fakeECRClient) PutImageTagMutability(repository string, mutability ecr.ImageTagMutability) error {
+	return nil
+}
+func (f *fakeECRClient) PutImageScanningConfiguration(repository string, scanOnPush bool) error {
+	return nil
+}
+func (f *fakeECRClient) SetTags(repository string, tags []ecr.Tag) error        { return nil }
+func (f *fakeECRClient) PutLifecyclePolicy(repository, policyText string) error { return nil }
+
+func TestBuildRegistryIDs_MergesAdditionalRegistriesAndTo(t *testing.T) {
+	registryIDs, err := buildRegistryIDs([]string{"111111111111"}, "222222222222.dkr.ecr.us-east-1.amazonaws.com")
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"111111111111", "222222222222"}, registryIDs)
+}
+
+func TestBuildRegistryIDs_RejectsInvalidTo(t *testing.T) {
+	_, err := buildRegistryIDs(nil, "not-a-registry-host")
+
+	require.Error(t, err)
+}
+
+func TestRunPushWithClient_RequestsOwnAccountAlongsideAdditionalRegistries(t *testing.T) {
+	client := &fakeECRClient{
+		uri: "111111111111.dkr.ecr.us-east-1.amazonaws.com/myrepo",
+		auths: []ecr.RegistryAuth{
+			{Username: "AWS", Password: "own-secret"},
+			{Username: "AWS", Password: "extra-secret"},
+		},
+	}
+	var stdout, stderr bytes.Buffer
+
+	err := runPushWithClient(client, "myrepo", []string{"222222222222"}, &stdout, &stderr)
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"222222222222"}, client.gotRegistryIDs)
+
+	decoder := json.NewDecoder(&stdout)
+	var got []ecr.RegistryAuth
+	for {
+		var auth ecr.RegistryAuth
+		if err := decoder.Decode(&auth); err != nil {
+			break
+		}
+		got = append(got, auth)
+	}
+	require.Equal(t, client.auths, got)
+}
+
+func TestRunPushWithClient_PropagatesAuthorizationError(t *testing.T) {
+	client := &fakeECRClient{err: errors.New("access denied")}
+	var stdout, stderr bytes.Buffer
+
+	err := runPushWithClient(client, "myrepo", nil, &stdout, &stderr)
+
+	require.EqualError(t, err, "access denied")
+}
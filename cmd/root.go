@@ -0,0 +1,59 @@
+// Package cmd wires trebuchet's CLI flags to the internal/ecr client.
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/hylandsoftware/trebuchet/internal/cliopts"
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/spf13/cobra"
+)
+
+var (
+	region               string
+	role                 string
+	profile              string
+	webIdentityTokenFile string
+	webIdentityRoleArn   string
+	ssoProfile           string
+	mfaSerial            string
+	sessionDuration      time.Duration
+)
+
+// RootCmd is the base trebuchet command; subcommands (push, etc.) attach to it.
+var RootCmd = &cobra.Command{
+	Use:   "trebuchet",
+	Short: "trebuchet builds and pushes container images to ECR",
+}
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&region, "region", "", "AWS region (defaults to the resolved AWS config region)")
+	RootCmd.PersistentFlags().StringVar(&role, "role", "", "ARN of an IAM role to assume before talking to ECR")
+	RootCmd.PersistentFlags().StringVar(&profile, "profile", "", "named AWS shared-config profile to use")
+	RootCmd.PersistentFlags().StringVar(&webIdentityTokenFile, "web-identity-token-file", "", "path to an OIDC token file for AssumeRoleWithWebIdentity (defaults to $AWS_WEB_IDENTITY_TOKEN_FILE)")
+	RootCmd.PersistentFlags().StringVar(&webIdentityRoleArn, "web-identity-role-arn", "", "role ARN to assume via AssumeRoleWithWebIdentity (defaults to $AWS_ROLE_ARN)")
+	RootCmd.PersistentFlags().StringVar(&ssoProfile, "sso-profile", "", "shorthand for --profile naming an AWS SSO (IAM Identity Center) enabled profile")
+	RootCmd.PersistentFlags().StringVar(&mfaSerial, "mfa-serial", "", "serial number (or ARN) of the MFA device required by --role's trust policy")
+	RootCmd.PersistentFlags().DurationVar(&sessionDuration, "session-duration", time.Hour, "lifetime requested for an assumed role's STS session")
+}
+
+// newECRClient builds an ecr.ECRClient from the resolved global flags, falling back to the
+// AWS_WEB_IDENTITY_TOKEN_FILE / AWS_ROLE_ARN environment variables that the EKS IRSA webhook
+// injects when the matching flags aren't set.
+func newECRClient() (ecr.ECRClient, error) {
+	if webIdentityTokenFile != "" {
+		os.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", webIdentityTokenFile)
+	}
+	if webIdentityRoleArn != "" {
+		os.Setenv("AWS_ROLE_ARN", webIdentityRoleArn)
+	}
+
+	roleOpts := ecr.RoleOptions{SessionDuration: sessionDuration}
+	if mfaSerial != "" {
+		roleOpts.MFASerial = mfaSerial
+		roleOpts.MFATokenProvider = cliopts.PromptForMFAToken
+	}
+
+	return ecr.NewClient(region, role, cliopts.ResolveProfile(profile, ssoProfile), roleOpts)
+}
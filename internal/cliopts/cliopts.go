@@ -0,0 +1,36 @@
+// Package cliopts holds small helpers shared between the `trebuchet` CLI (cmd) and the
+// docker-credential-trebuchet binary, so the two don't maintain duplicate copies of the same
+// MFA-prompt and profile-precedence logic.
+package cliopts
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// PromptForMFAToken reads a one-time MFA token code from stdin, matching the shape of the
+// AWS SDK's stscreds.StdinTokenProvider.
+func PromptForMFAToken() (string, error) {
+	fmt.Fprintf(os.Stderr, "Assume Role MFA token code: ")
+
+	reader := bufio.NewReader(os.Stdin)
+	token, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(token), nil
+}
+
+// ResolveProfile applies the rule that an SSO profile takes precedence over a plain named
+// profile, shared by --sso-profile/--profile and their TREBUCHET_SSO_PROFILE/
+// TREBUCHET_PROFILE environment-variable equivalents.
+func ResolveProfile(profile, ssoProfile string) string {
+	if ssoProfile != "" {
+		return ssoProfile
+	}
+
+	return profile
+}
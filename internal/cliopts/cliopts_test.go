@@ -0,0 +1,15 @@
+package cliopts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveProfile_PrefersSSOProfile(t *testing.T) {
+	require.Equal(t, "my-sso-profile", ResolveProfile("my-profile", "my-sso-profile"))
+}
+
+func TestResolveProfile_FallsBackToProfile(t *testing.T) {
+	require.Equal(t, "my-profile", ResolveProfile("my-profile", ""))
+}
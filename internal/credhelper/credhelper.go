@@ -0,0 +1,275 @@
+// Package credhelper implements the Docker credential helper protocol
+// (https://github.com/docker/docker-credential-helpers#development) on top of
+// internal/ecr's GetAuthorizationToken, so trebuchet can be installed on $PATH as
+// docker-credential-trebuchet and let `docker login`/`docker pull`/`docker push` resolve
+// ECR credentials automatically instead of requiring a separate `aws ecr get-login-password`
+// step before every push.
+package credhelper
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+)
+
+// credentials is the Docker credential helper protocol's wire format: `get` emits it,
+// `store` receives it.
+type credentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// cacheEntry is what Helper persists to disk between invocations, keyed by registry+role+
+// profile, so that repeated `docker pull`s against the same registry don't each mint a new
+// STS/ECR token.
+type cacheEntry struct {
+	ServerURL string    `json:"ServerURL"`
+	Username  string    `json:"Username"`
+	Secret    string    `json:"Secret"`
+	ExpiresAt time.Time `json:"ExpiresAt"`
+}
+
+// ecrHostPattern matches an ECR registry hostname, e.g.
+// 123456789012.dkr.ecr.us-east-1.amazonaws.com (and the .com.cn partition variant), and
+// captures the region so Helper can resolve credentials without a --region flag.
+var ecrHostPattern = regexp.MustCompile(`^\d+\.dkr\.ecr\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// ClientFactory builds an ecr.ECRClient for the given region/role/profile, matching
+// ecr.NewClient's signature so tests can substitute a fake without talking to AWS.
+type ClientFactory func(region, role, profile string, roleOpts ecr.RoleOptions) (ecr.ECRClient, error)
+
+// Helper serves the Docker credential helper protocol's get/store/erase/list verbs against
+// ECR. Role/Profile/RoleOpts carry the same credential-resolution settings as the
+// `trebuchet` CLI's --role/--profile/--sso-profile/--mfa-serial flags; since Docker invokes
+// credential helpers with no flags of its own, these must come from the environment (see
+// cmd/docker-credential-trebuchet).
+type Helper struct {
+	NewClient ClientFactory
+	Role      string
+	Profile   string
+	RoleOpts  ecr.RoleOptions
+
+	// CacheDir holds one JSON file per registry+role+profile cache entry. Defaults to
+	// os.UserCacheDir()/trebuchet/credhelper when empty.
+	CacheDir string
+}
+
+// Serve dispatches verb (one of get/store/erase/list), reading its request body from in and
+// writing the protocol's response to out.
+func (h *Helper) Serve(verb string, in io.Reader, out io.Writer) error {
+	switch verb {
+	case "get":
+		return h.get(in, out)
+	case "store":
+		return h.store(in)
+	case "erase":
+		return h.erase(in)
+	case "list":
+		return h.list(out)
+	default:
+		return fmt.Errorf("credhelper: unknown verb %q", verb)
+	}
+}
+
+// get resolves in's raw server URL to ECR credentials, serving a cached token if one is
+// still valid and minting a fresh one via GetAuthorizationToken otherwise.
+func (h *Helper) get(in io.Reader, out io.Writer) error {
+	serverURL, err := readLine(in)
+	if err != nil {
+		return err
+	}
+
+	region, err := regionFromServerURL(serverURL)
+	if err != nil {
+		return err
+	}
+
+	cachePath, err := h.cacheFilePath(serverURL)
+	if err != nil {
+		return err
+	}
+
+	if cached, ok := readCacheEntry(cachePath); ok {
+		return writeJSON(out, credentials{ServerURL: serverURL, Username: cached.Username, Secret: cached.Secret})
+	}
+
+	client, err := h.NewClient(region, h.Role, h.Profile, h.RoleOpts)
+	if err != nil {
+		return err
+	}
+
+	auth, err := client.GetAuthorizationToken()
+	if err != nil {
+		return err
+	}
+
+	entry := cacheEntry{ServerURL: serverURL, Username: auth.Username, Secret: auth.Password, ExpiresAt: auth.ExpiresAt}
+	if err := writeCacheEntry(cachePath, entry); err != nil {
+		return err
+	}
+
+	return writeJSON(out, credentials{ServerURL: serverURL, Username: auth.Username, Secret: auth.Password})
+}
+
+// store is a protocol no-op: ECR tokens can't be stored back, only minted, so the next get
+// simply mints (and caches) a fresh one once this one expires.
+func (h *Helper) store(in io.Reader) error {
+	var creds credentials
+	return json.NewDecoder(in).Decode(&creds)
+}
+
+// erase drops any cached entry for in's raw server URL.
+func (h *Helper) erase(in io.Reader) error {
+	serverURL, err := readLine(in)
+	if err != nil {
+		return err
+	}
+
+	cachePath, err := h.cacheFilePath(serverURL)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(cachePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// list enumerates the registries with a cached (not necessarily unexpired) entry, mapping
+// each to its cached username.
+func (h *Helper) list(out io.Writer) error {
+	dir, err := h.cacheDir()
+	if err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return writeJSON(out, map[string]string{})
+		}
+		return err
+	}
+
+	result := make(map[string]string, len(entries))
+	for _, fileInfo := range entries {
+		data, err := ioutil.ReadFile(filepath.Join(dir, fileInfo.Name()))
+		if err != nil {
+			continue
+		}
+
+		var stored cacheEntry
+		if err := json.Unmarshal(data, &stored); err != nil {
+			continue
+		}
+
+		result[stored.ServerURL] = stored.Username
+	}
+
+	return writeJSON(out, result)
+}
+
+// cacheFilePath returns the on-disk path for serverURL's cache entry, keyed by
+// registry+role+profile so distinct --role/--profile invocations against the same registry
+// don't collide.
+func (h *Helper) cacheFilePath(serverURL string) (string, error) {
+	dir, err := h.cacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha1.Sum([]byte(strings.Join([]string{serverURL, h.Role, h.Profile}, "|")))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json"), nil
+}
+
+func (h *Helper) cacheDir() (string, error) {
+	if h.CacheDir != "" {
+		return h.CacheDir, nil
+	}
+
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(base, "trebuchet", "credhelper"), nil
+}
+
+// readCacheEntry returns a cache entry if cachePath holds one that hasn't expired yet.
+func readCacheEntry(cachePath string) (*cacheEntry, bool) {
+	data, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		return nil, false
+	}
+
+	var stored cacheEntry
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, false
+	}
+
+	if !time.Now().Before(stored.ExpiresAt) {
+		return nil, false
+	}
+
+	return &stored, true
+}
+
+func writeCacheEntry(cachePath string, entry cacheEntry) error {
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(cachePath, data, 0600)
+}
+
+func regionFromServerURL(serverURL string) (string, error) {
+	host := serverURL
+	if idx := strings.Index(host, "://"); idx != -1 {
+		host = host[idx+len("://"):]
+	}
+	host = strings.TrimSuffix(host, "/")
+
+	match := ecrHostPattern.FindStringSubmatch(host)
+	if match == nil {
+		return "", fmt.Errorf("credhelper: %q is not an ECR registry hostname", serverURL)
+	}
+
+	return match[1], nil
+}
+
+func readLine(in io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return "", err
+	}
+
+	line := strings.TrimSpace(string(data))
+	if line == "" {
+		return "", errors.New("credhelper: empty request body")
+	}
+
+	return line, nil
+}
+
+func writeJSON(out io.Writer, v interface{}) error {
+	return json.NewEncoder(out).Encode(v)
+}
@@ -0,0 +1,184 @@
+package credhelper
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/stretchr/testify/require"
+)
+
+// stubECRClient implements ecr.ECRClient, returning canned GetAuthorizationToken results so
+// tests never talk to AWS. The other methods come from the embedded nil ecr.ECRClient and
+// panic if called; credhelper never calls them.
+type stubECRClient struct {
+	ecr.ECRClient
+	auth *ecr.RegistryAuth
+	err  error
+}
+
+func (c *stubECRClient) GetAuthorizationToken() (*ecr.RegistryAuth, error) {
+	return c.auth, c.err
+}
+
+func newFactory(auth *ecr.RegistryAuth, err error) ClientFactory {
+	return func(region, role, profile string, roleOpts ecr.RoleOptions) (ecr.ECRClient, error) {
+		return &stubECRClient{auth: auth, err: err}, nil
+	}
+}
+
+func TestHelper_Serve(t *testing.T) {
+	tests := []struct {
+		name       string
+		verb       string
+		stdin      string
+		factory    ClientFactory
+		wantStdout string
+		wantErr    string
+	}{
+		{
+			name:       "get mints credentials for a valid ECR hostname",
+			verb:       "get",
+			stdin:      "123456789012.dkr.ecr.us-east-1.amazonaws.com",
+			factory:    newFactory(&ecr.RegistryAuth{Username: "AWS", Password: "secret", ExpiresAt: time.Now().Add(time.Hour)}, nil),
+			wantStdout: `{"ServerURL":"123456789012.dkr.ecr.us-east-1.amazonaws.com","Username":"AWS","Secret":"secret"}` + "\n",
+		},
+		{
+			name:       "get strips a scheme and trailing slash before matching the hostname",
+			verb:       "get",
+			stdin:      "https://123456789012.dkr.ecr.eu-west-1.amazonaws.com/",
+			factory:    newFactory(&ecr.RegistryAuth{Username: "AWS", Password: "secret", ExpiresAt: time.Now().Add(time.Hour)}, nil),
+			wantStdout: `{"ServerURL":"https://123456789012.dkr.ecr.eu-west-1.amazonaws.com/","Username":"AWS","Secret":"secret"}` + "\n",
+		},
+		{
+			name:    "get rejects a non-ECR hostname",
+			verb:    "get",
+			stdin:   "index.docker.io",
+			wantErr: `credhelper: "index.docker.io" is not an ECR registry hostname`,
+		},
+		{
+			name:    "get propagates GetAuthorizationToken errors",
+			verb:    "get",
+			stdin:   "123456789012.dkr.ecr.us-east-1.amazonaws.com",
+			factory: newFactory(nil, errors.New("access denied")),
+			wantErr: "access denied",
+		},
+		{
+			name:       "store acknowledges without error",
+			verb:       "store",
+			stdin:      `{"ServerURL":"123456789012.dkr.ecr.us-east-1.amazonaws.com","Username":"AWS","Secret":"secret"}`,
+			wantStdout: "",
+		},
+		{
+			name:       "erase on an uncached registry is a no-op",
+			verb:       "erase",
+			stdin:      "123456789012.dkr.ecr.us-east-1.amazonaws.com",
+			wantStdout: "",
+		},
+		{
+			name:       "list on an empty cache returns an empty object",
+			verb:       "list",
+			wantStdout: "{}\n",
+		},
+		{
+			name:    "unknown verb is rejected",
+			verb:    "rotate",
+			wantErr: `credhelper: unknown verb "rotate"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Helper{NewClient: tt.factory, CacheDir: t.TempDir()}
+
+			var stdout bytes.Buffer
+			err := h.Serve(tt.verb, bytes.NewBufferString(tt.stdin), &stdout)
+
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.wantStdout, stdout.String())
+		})
+	}
+}
+
+func TestHelper_Serve_GetReusesCachedTokenWithoutMintingANewOne(t *testing.T) {
+	calls := 0
+	factory := func(region, role, profile string, roleOpts ecr.RoleOptions) (ecr.ECRClient, error) {
+		calls++
+		return &stubECRClient{auth: &ecr.RegistryAuth{Username: "AWS", Password: "secret", ExpiresAt: time.Now().Add(time.Hour)}}, nil
+	}
+
+	h := &Helper{NewClient: factory, CacheDir: t.TempDir()}
+
+	var first, second bytes.Buffer
+	require.NoError(t, h.Serve("get", bytes.NewBufferString("123456789012.dkr.ecr.us-east-1.amazonaws.com"), &first))
+	require.NoError(t, h.Serve("get", bytes.NewBufferString("123456789012.dkr.ecr.us-east-1.amazonaws.com"), &second))
+
+	require.Equal(t, 1, calls)
+	require.Equal(t, first.String(), second.String())
+}
+
+func TestHelper_Serve_GetRefreshesAnExpiredCacheEntry(t *testing.T) {
+	calls := 0
+	factory := func(region, role, profile string, roleOpts ecr.RoleOptions) (ecr.ECRClient, error) {
+		calls++
+		return &stubECRClient{auth: &ecr.RegistryAuth{Username: "AWS", Password: "refreshed-secret", ExpiresAt: time.Now().Add(time.Hour)}}, nil
+	}
+
+	h := &Helper{NewClient: factory, CacheDir: t.TempDir()}
+	serverURL := "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+
+	cachePath, err := h.cacheFilePath(serverURL)
+	require.NoError(t, err)
+	require.NoError(t, writeCacheEntry(cachePath, cacheEntry{
+		ServerURL: serverURL,
+		Username:  "AWS",
+		Secret:    "stale-secret",
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}))
+
+	var stdout bytes.Buffer
+	require.NoError(t, h.Serve("get", bytes.NewBufferString(serverURL), &stdout))
+
+	require.Equal(t, 1, calls)
+	require.Contains(t, stdout.String(), "refreshed-secret")
+}
+
+func TestHelper_Serve_ListEnumeratesCachedRegistries(t *testing.T) {
+	h := &Helper{CacheDir: t.TempDir()}
+
+	for _, entry := range []cacheEntry{
+		{ServerURL: "111111111111.dkr.ecr.us-east-1.amazonaws.com", Username: "AWS", Secret: "a", ExpiresAt: time.Now().Add(time.Hour)},
+		{ServerURL: "222222222222.dkr.ecr.us-west-2.amazonaws.com", Username: "AWS", Secret: "b", ExpiresAt: time.Now().Add(-time.Hour)},
+	} {
+		path, err := h.cacheFilePath(entry.ServerURL)
+		require.NoError(t, err)
+		require.NoError(t, writeCacheEntry(path, entry))
+	}
+
+	var stdout bytes.Buffer
+	require.NoError(t, h.Serve("list", nil, &stdout))
+
+	require.Contains(t, stdout.String(), "111111111111.dkr.ecr.us-east-1.amazonaws.com")
+	require.Contains(t, stdout.String(), "222222222222.dkr.ecr.us-west-2.amazonaws.com")
+}
+
+func TestHelper_Serve_EraseRemovesACachedEntry(t *testing.T) {
+	h := &Helper{CacheDir: t.TempDir()}
+	serverURL := "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+
+	cachePath, err := h.cacheFilePath(serverURL)
+	require.NoError(t, err)
+	require.NoError(t, writeCacheEntry(cachePath, cacheEntry{ServerURL: serverURL, Username: "AWS", Secret: "secret", ExpiresAt: time.Now().Add(time.Hour)}))
+
+	require.NoError(t, h.Serve("erase", bytes.NewBufferString(serverURL), nil))
+
+	_, ok := readCacheEntry(cachePath)
+	require.False(t, ok)
+}
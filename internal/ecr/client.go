@@ -0,0 +1,446 @@
+// Package ecr provides trebuchet's thin wrapper around the AWS SDK's ECR and STS clients:
+// resolving credentials (static, shared-config profile, or an assumed role) and exposing
+// the handful of repository/auth operations the push pipeline needs.
+package ecr
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/aws/endpoints"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	stssdk "github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/hylandsoftware/trebuchet/internal/sts"
+)
+
+// defaultSessionName is used when assuming a role without an explicit session name, so
+// assumed-role sessions are easy to spot in CloudTrail.
+const defaultSessionName = "trebuchet"
+
+// defaultSessionDuration is the STS session lifetime used when --session-duration isn't set.
+const defaultSessionDuration = time.Hour
+
+// ErrMFARequired is re-exported from internal/sts so callers can errors.Is against it
+// without importing that package directly.
+var ErrMFARequired = sts.ErrMFARequired
+
+// RegistryAuth holds the basic-auth credentials and target registry decoded from an ECR
+// authorization token, plus the time at which that token expires.
+type RegistryAuth struct {
+	Username      string
+	Password      string
+	ProxyEndpoint string
+	ExpiresAt     time.Time
+}
+
+// ECRClient is the subset of ECR operations the push pipeline depends on.
+type ECRClient interface {
+	RepositoryExists(repository string) (bool, error)
+	CreateRepository(repository string, config RepositoryConfig) error
+	GetRepositoryURI(repository string) (string, error)
+	GetAuthorizationToken() (*RegistryAuth, error)
+
+	// GetAuthorizationTokensFor always resolves the caller's own-account token first,
+	// then appends one RegistryAuth per account in registryIDs, letting a single
+	// invocation authenticate against the home account plus several cross-account ECR
+	// registries (e.g. dev/stage/prod) without re-running the assume-role dance per
+	// target. An empty registryIDs falls back to GetAuthorizationToken's single-account
+	// (the caller's own) behavior.
+	GetAuthorizationTokensFor(registryIDs []string) ([]RegistryAuth, error)
+
+	// GetRepositoryConfig, PutImageTagMutability, PutImageScanningConfiguration, SetTags,
+	// and PutLifecyclePolicy let SetupRepository reconcile an existing repository toward a
+	// RepositoryConfig instead of silently ignoring it.
+	GetRepositoryConfig(repository string) (*RepositoryConfig, error)
+	PutImageTagMutability(repository string, mutability ImageTagMutability) error
+	PutImageScanningConfiguration(repository string, scanOnPush bool) error
+	SetTags(repository string, tags []Tag) error
+	PutLifecyclePolicy(repository, policyText string) error
+}
+
+// roleAssumer is the narrow seam over STS that getClientConfig uses, so tests can
+// exercise credential resolution without talking to AWS.
+type roleAssumer interface {
+	AssumeRole(config aws.Config, arnRole string, roleOpts RoleOptions) (*sts.CredentialsProvider, error)
+	AssumeRoleWithWebIdentity(config aws.Config, roleArn, sessionName, tokenFilePath string) (*sts.CredentialsProvider, error)
+}
+
+type defaultRoleAssumer struct{}
+
+func (defaultRoleAssumer) AssumeRole(config aws.Config, arnRole string, roleOpts RoleOptions) (*sts.CredentialsProvider, error) {
+	provider := sts.NewCredentialsProvider(stssdk.New(config), arnRole, defaultSessionName)
+	provider.MFASerial = roleOpts.MFASerial
+	provider.MFATokenProvider = roleOpts.MFATokenProvider
+
+	provider.SessionDuration = defaultSessionDuration
+	if roleOpts.SessionDuration > 0 {
+		provider.SessionDuration = roleOpts.SessionDuration
+	}
+
+	return provider, nil
+}
+
+func (defaultRoleAssumer) AssumeRoleWithWebIdentity(config aws.Config, roleArn, sessionName, tokenFilePath string) (*sts.CredentialsProvider, error) {
+	if sessionName == "" {
+		sessionName = defaultSessionName
+	}
+
+	return sts.NewWebIdentityCredentialsProvider(stssdk.New(config), roleArn, sessionName, tokenFilePath), nil
+}
+
+// RoleOptions carries the optional, less-common ways of assuming a role. The zero value
+// means "just use --role with the default credential chain".
+type RoleOptions struct {
+	// WebIdentityTokenFile and WebIdentityRoleArn configure AssumeRoleWithWebIdentity for
+	// EKS/IRSA workloads, mirroring the AWS_WEB_IDENTITY_TOKEN_FILE / AWS_ROLE_ARN env vars.
+	WebIdentityTokenFile string
+	WebIdentityRoleArn   string
+
+	// MFASerial and MFATokenProvider are set when --role points at a policy that requires
+	// aws:MultiFactorAuthPresent. MFATokenProvider is called once per STS session refresh.
+	MFASerial        string
+	MFATokenProvider func() (string, error)
+
+	// SessionDuration requests a non-default STS session lifetime for an assumed role
+	// (defaultSessionDuration is used when zero).
+	SessionDuration time.Duration
+}
+
+// webIdentityOptionsFromEnv resolves RoleOptions from the environment variables the EKS
+// IRSA webhook injects into every annotated pod.
+func webIdentityOptionsFromEnv() RoleOptions {
+	return RoleOptions{
+		WebIdentityTokenFile: os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"),
+		WebIdentityRoleArn:   os.Getenv("AWS_ROLE_ARN"),
+	}
+}
+
+func getClientConfig(region, role, profile string, roleOpts RoleOptions, assumer roleAssumer, loadConfig func(configs ...external.Config) (aws.Config, error)) (aws.Config, error) {
+	var opts []external.Config
+	if profile != "" {
+		opts = append(opts, external.WithSharedConfigProfile(profile))
+	}
+
+	cfg, err := loadConfig(opts...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	if region != "" {
+		cfg.Region = region
+	}
+
+	if profile != "" {
+		ssoProfile, ok, err := ssoProfileFromConfig(profile)
+		if err != nil {
+			return aws.Config{}, err
+		}
+		if ok {
+			cfg.Credentials = newSSOCredentialsProvider(cfg, *ssoProfile)
+		}
+	}
+
+	if cfg.Credentials == nil {
+		return aws.Config{}, &ErrNoCredentials{Profile: profile, Region: cfg.Region}
+	}
+
+	if _, err := endpoints.NewDefaultResolver().ResolveEndpoint(ecr.EndpointsID, cfg.Region); err != nil {
+		return aws.Config{}, &ErrRegionRequired{Region: cfg.Region, Err: err}
+	}
+
+	switch {
+	case roleOpts.WebIdentityTokenFile != "" && roleOpts.WebIdentityRoleArn != "":
+		provider, err := assumer.AssumeRoleWithWebIdentity(cfg, roleOpts.WebIdentityRoleArn, defaultSessionName, roleOpts.WebIdentityTokenFile)
+		if err != nil {
+			return aws.Config{}, &ErrAssumeRoleFailed{RoleArn: roleOpts.WebIdentityRoleArn, Err: err}
+		}
+		cfg.Credentials = provider
+	case role != "":
+		provider, err := assumer.AssumeRole(cfg, role, roleOpts)
+		if err != nil {
+			return aws.Config{}, &ErrAssumeRoleFailed{RoleArn: role, Err: err}
+		}
+		cfg.Credentials = provider
+	}
+
+	return cfg, nil
+}
+
+// NewClient resolves credentials for region/role/profile (falling back to
+// AWS_WEB_IDENTITY_TOKEN_FILE / AWS_ROLE_ARN for IRSA workloads when role is empty) and
+// returns a ready-to-use ECR client. roleOpts carries the less-common ways of assuming
+// role, such as MFA or web identity; pass the zero value for the common case.
+func NewClient(region, role, profile string, roleOpts RoleOptions) (ECRClient, error) {
+	if role == "" && roleOpts.WebIdentityTokenFile == "" && roleOpts.WebIdentityRoleArn == "" {
+		envOpts := webIdentityOptionsFromEnv()
+		roleOpts.WebIdentityTokenFile = envOpts.WebIdentityTokenFile
+		roleOpts.WebIdentityRoleArn = envOpts.WebIdentityRoleArn
+	}
+
+	cfg, err := getClientConfig(region, role, profile, roleOpts, defaultRoleAssumer{}, external.LoadDefaultAWSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	crossAccountRole, parseErr := arn.Parse(role)
+
+	return &ecrClient{
+		client:              ecr.New(cfg),
+		cfg:                 cfg,
+		assumer:             defaultRoleAssumer{},
+		roleOpts:            roleOpts,
+		crossAccountRole:    crossAccountRole,
+		hasCrossAccountRole: parseErr == nil,
+	}, nil
+}
+
+type ecrClient struct {
+	client *ecr.Client
+
+	// cfg, assumer, roleOpts, crossAccountRole, and hasCrossAccountRole back
+	// fetchAuthorizationTokenForRegistry's cross-account AssumeRole, and are unset (zero
+	// value) on a client built directly around an *ecr.Client in tests that don't exercise
+	// that path. crossAccountRole is --role parsed as an ARN, so each target account's role
+	// can be derived with the same partition, service, and resource (role name), just a
+	// different account ID; hasCrossAccountRole is false when --role wasn't a parseable role
+	// ARN, e.g. when the caller authenticated with static or profile credentials instead.
+	cfg                 aws.Config
+	assumer             roleAssumer
+	roleOpts            RoleOptions
+	crossAccountRole    arn.ARN
+	hasCrossAccountRole bool
+}
+
+func (c *ecrClient) RepositoryExists(repository string) (bool, error) {
+	req := c.client.DescribeRepositoriesRequest(&ecr.DescribeRepositoriesInput{
+		RepositoryNames: []string{repository},
+	})
+
+	_, err := req.Send(context.Background())
+	if err != nil {
+		if isAWSErrorCode(err, ecr.ErrCodeRepositoryNotFoundException) {
+			return false, nil
+		}
+		return false, wrapRepositoryError(repository, err)
+	}
+
+	return true, nil
+}
+
+func (c *ecrClient) GetRepositoryURI(repository string) (string, error) {
+	req := c.client.DescribeRepositoriesRequest(&ecr.DescribeRepositoriesInput{
+		RepositoryNames: []string{repository},
+	})
+
+	resp, err := req.Send(context.Background())
+	if err != nil {
+		return "", wrapRepositoryError(repository, err)
+	}
+
+	if len(resp.Repositories) == 0 {
+		return "", &ErrRepositoryNotFound{Repository: repository}
+	}
+
+	return aws.StringValue(resp.Repositories[0].RepositoryUri), nil
+}
+
+func (c *ecrClient) GetAuthorizationToken() (*RegistryAuth, error) {
+	req := c.client.GetAuthorizationTokenRequest(&ecr.GetAuthorizationTokenInput{})
+
+	resp, err := req.Send(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.AuthorizationData) == 0 {
+		return nil, errors.New("no authorization data returned")
+	}
+
+	data := resp.AuthorizationData[0]
+	auth, err := extractToken(aws.StringValue(data.AuthorizationToken), aws.StringValue(data.ProxyEndpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	auth.ExpiresAt = aws.TimeValue(data.ExpiresAt)
+	return auth, nil
+}
+
+// GetAuthorizationTokensFor resolves the caller's own-account RegistryAuth plus one per
+// registryID, letting a single invocation authenticate against the home account and
+// several cross-account ECR registries without re-running the assume-role dance per
+// target.
+func (c *ecrClient) GetAuthorizationTokensFor(registryIDs []string) ([]RegistryAuth, error) {
+	return getAuthorizationTokensFor(registryIDs, c.GetAuthorizationToken, c.fetchAuthorizationTokenForRegistry)
+}
+
+// fetchAuthorizationTokenForRegistry authenticates against a single cross-account registry.
+//
+// ECR's GetAuthorizationToken has long treated RegistryIds as a no-op for this operation —
+// it always returns a token scoped to the caller's own account, regardless of what's passed
+// there — so registryID access instead goes through an AssumeRole hop into that account,
+// assuming a role of the same name as the one --role pointed at for the caller's own
+// account, then fetching that role's own-account token the ordinary way.
+func (c *ecrClient) fetchAuthorizationTokenForRegistry(registryID string) (*RegistryAuth, error) {
+	if !c.hasCrossAccountRole {
+		return nil, errors.New("cross-account authentication requires --role, so trebuchet knows which role to assume in the target account")
+	}
+
+	target := c.crossAccountRole
+	target.AccountID = registryID
+	targetRoleArn := target.String()
+
+	provider, err := c.assumer.AssumeRole(c.cfg, targetRoleArn, c.roleOpts)
+	if err != nil {
+		return nil, &ErrAssumeRoleFailed{RoleArn: targetRoleArn, Err: err}
+	}
+
+	targetCfg := c.cfg.Copy()
+	targetCfg.Credentials = provider
+
+	return (&ecrClient{client: ecr.New(targetCfg)}).GetAuthorizationToken()
+}
+
+// getAuthorizationTokensFor contains GetAuthorizationTokensFor's control flow, taking
+// single/fetch as seams so tests can exercise its empty-slice fallback and partial-failure
+// behavior without talking to AWS. The caller's own-account token via single is always
+// resolved and always comes first in the result, with one entry per registryID appended
+// after it, so a single invocation authenticates against the home account *and* every
+// cross-account target rather than the targets alone. It stops and reports the first
+// failing registry rather than returning a partial result, so callers can't mistake a
+// partially-authenticated push for a fully-authenticated one.
+func getAuthorizationTokensFor(registryIDs []string, single func() (*RegistryAuth, error), fetch func(registryID string) (*RegistryAuth, error)) ([]RegistryAuth, error) {
+	own, err := single()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(registryIDs) == 0 {
+		return []RegistryAuth{*own}, nil
+	}
+
+	auths := make([]RegistryAuth, 0, len(registryIDs)+1)
+	auths = append(auths, *own)
+	for _, registryID := range registryIDs {
+		auth, err := fetch(registryID)
+		if err != nil {
+			return nil, fmt.Errorf("registry %s: %w", registryID, err)
+		}
+
+		auths = append(auths, *auth)
+	}
+
+	return auths, nil
+}
+
+func extractToken(token, proxyEndpoint string) (*RegistryAuth, error) {
+	decoded, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return nil, &ErrInvalidToken{Err: err}
+	}
+
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return nil, &ErrInvalidToken{Err: fmt.Errorf("expected two parts, got %d", len(parts))}
+	}
+
+	return &RegistryAuth{
+		Username:      parts[0],
+		Password:      parts[1],
+		ProxyEndpoint: proxyEndpoint,
+	}, nil
+}
+
+// SetupRepository ensures repository exists with config applied, creating it if necessary
+// or reconciling drift on each configured attribute if it already exists, and returns its
+// URI.
+func SetupRepository(client ECRClient, repository string, config RepositoryConfig) (string, error) {
+	exists, err := client.RepositoryExists(repository)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists {
+		if err := client.CreateRepository(repository, config); err != nil {
+			return "", err
+		}
+	} else if !isZeroRepositoryConfig(config) {
+		if err := reconcileRepositoryConfig(client, repository, config); err != nil {
+			return "", err
+		}
+	}
+
+	return client.GetRepositoryURI(repository)
+}
+
+// isZeroRepositoryConfig reports whether config requests no particular configuration, so
+// SetupRepository can skip reconciliation entirely for callers that only want a bare
+// repository (matching the old CreateRepository-with-defaults behavior).
+func isZeroRepositoryConfig(config RepositoryConfig) bool {
+	return config.ImageTagMutability == "" &&
+		!config.ScanOnPush &&
+		len(config.Tags) == 0 &&
+		config.LifecyclePolicyText == ""
+}
+
+// reconcileRepositoryConfig diffs an existing repository's configuration against desired
+// and applies only the update APIs needed to close the gap.
+func reconcileRepositoryConfig(client ECRClient, repository string, desired RepositoryConfig) error {
+	current, err := client.GetRepositoryConfig(repository)
+	if err != nil {
+		return err
+	}
+
+	if desired.ImageTagMutability != "" && desired.ImageTagMutability != current.ImageTagMutability {
+		if err := client.PutImageTagMutability(repository, desired.ImageTagMutability); err != nil {
+			return err
+		}
+	}
+
+	if desired.ScanOnPush != current.ScanOnPush {
+		if err := client.PutImageScanningConfiguration(repository, desired.ScanOnPush); err != nil {
+			return err
+		}
+	}
+
+	if len(desired.Tags) > 0 && !tagsEqual(desired.Tags, current.Tags) {
+		if err := client.SetTags(repository, desired.Tags); err != nil {
+			return err
+		}
+	}
+
+	if desired.LifecyclePolicyText != "" && desired.LifecyclePolicyText != current.LifecyclePolicyText {
+		if err := client.PutLifecyclePolicy(repository, desired.LifecyclePolicyText); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func tagsEqual(a, b []Tag) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	byKey := make(map[string]string, len(a))
+	for _, tag := range a {
+		byKey[tag.Key] = tag.Value
+	}
+
+	for _, tag := range b {
+		if value, ok := byKey[tag.Key]; !ok || value != tag.Value {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,12 +1,18 @@
 package ecr
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/aws/aws-sdk-go-v2/aws/external"
 	"github.com/hylandsoftware/trebuchet/internal/sts"
 	"github.com/stretchr/testify/assert"
@@ -18,8 +24,13 @@ type mockRoleAssumer struct {
 	mock.Mock
 }
 
-func (m *mockRoleAssumer) AssumeRole(config aws.Config, arnRole string) (*sts.CredentialsProvider, error) {
-	args := m.Called(config, arnRole)
+func (m *mockRoleAssumer) AssumeRole(config aws.Config, arnRole string, roleOpts RoleOptions) (*sts.CredentialsProvider, error) {
+	args := m.Called(config, arnRole, roleOpts)
+	return args.Get(0).(*sts.CredentialsProvider), args.Error(1)
+}
+
+func (m *mockRoleAssumer) AssumeRoleWithWebIdentity(config aws.Config, roleArn, sessionName, tokenFilePath string) (*sts.CredentialsProvider, error) {
+	args := m.Called(config, roleArn, sessionName, tokenFilePath)
 	return args.Get(0).(*sts.CredentialsProvider), args.Error(1)
 }
 
@@ -32,8 +43,8 @@ func (m *mockECRClient) RepositoryExists(repository string) (bool, error) {
 	return args.Bool(0), args.Error(1)
 }
 
-func (m *mockECRClient) CreateRepository(repository string) error {
-	args := m.Called(repository)
+func (m *mockECRClient) CreateRepository(repository string, config RepositoryConfig) error {
+	args := m.Called(repository, config)
 	return args.Error(0)
 }
 
@@ -47,12 +58,45 @@ func (m *mockECRClient) GetAuthorizationToken() (*RegistryAuth, error) {
 	return args.Get(0).(*RegistryAuth), args.Error(1)
 }
 
+func (m *mockECRClient) GetAuthorizationTokensFor(registryIDs []string) ([]RegistryAuth, error) {
+	args := m.Called(registryIDs)
+	if result := args.Get(0); result != nil {
+		return result.([]RegistryAuth), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *mockECRClient) GetRepositoryConfig(repository string) (*RepositoryConfig, error) {
+	args := m.Called(repository)
+	return args.Get(0).(*RepositoryConfig), args.Error(1)
+}
+
+func (m *mockECRClient) PutImageTagMutability(repository string, mutability ImageTagMutability) error {
+	args := m.Called(repository, mutability)
+	return args.Error(0)
+}
+
+func (m *mockECRClient) PutImageScanningConfiguration(repository string, scanOnPush bool) error {
+	args := m.Called(repository, scanOnPush)
+	return args.Error(0)
+}
+
+func (m *mockECRClient) SetTags(repository string, tags []Tag) error {
+	args := m.Called(repository, tags)
+	return args.Error(0)
+}
+
+func (m *mockECRClient) PutLifecyclePolicy(repository, policyText string) error {
+	args := m.Called(repository, policyText)
+	return args.Error(0)
+}
+
 func TestEcrClient_GetClientConfig_AssumeRoleUpdatesNewCredentials(t *testing.T) {
 	m := &mockRoleAssumer{}
 	dummyCredProvider := &sts.CredentialsProvider{}
-	m.On("AssumeRole", mock.Anything, "testing").Return(dummyCredProvider, nil)
+	m.On("AssumeRole", mock.Anything, "testing", mock.Anything).Return(dummyCredProvider, nil)
 
-	result, err := getClientConfig("us-east-1", "testing", "", m, func(configs ...external.Config) (aws.Config, error) {
+	result, err := getClientConfig("us-east-1", "testing", "", RoleOptions{}, m, func(configs ...external.Config) (aws.Config, error) {
 		return aws.Config{
 			Region:      "us-east-1",
 			Credentials: dummyCredProvider,
@@ -66,23 +110,26 @@ func TestEcrClient_GetClientConfig_AssumeRoleUpdatesNewCredentials(t *testing.T)
 func TestEcrClient_GetClientConfig_ReturnsErrorOnBadAssumeRole(t *testing.T) {
 	m := &mockRoleAssumer{}
 	dummyCredProvider := &sts.CredentialsProvider{}
-	m.On("AssumeRole", mock.Anything, "testing").Return(dummyCredProvider, errors.New("some error"))
+	m.On("AssumeRole", mock.Anything, "testing", mock.Anything).Return(dummyCredProvider, errors.New("some error"))
 
-	_, err := getClientConfig("us-east-1", "testing", "", m, func(configs ...external.Config) (aws.Config, error) {
+	_, err := getClientConfig("us-east-1", "testing", "", RoleOptions{}, m, func(configs ...external.Config) (aws.Config, error) {
 		return aws.Config{
 			Region:      "us-east-1",
 			Credentials: dummyCredProvider,
 		}, nil
 	})
 
-	require.EqualError(t, err, "some error")
+	var assumeErr *ErrAssumeRoleFailed
+	require.ErrorAs(t, err, &assumeErr)
+	require.Equal(t, "testing", assumeErr.RoleArn)
+	require.EqualError(t, assumeErr.Err, "some error")
 }
 
 func TestEcrClient_GetClientConfig_RegionFlagUpdatesConfigRegion(t *testing.T) {
 	m := &mockRoleAssumer{}
 	dummyCredProvider := &sts.CredentialsProvider{}
 
-	result, err := getClientConfig("us-east-2", "", "", m, func(configs ...external.Config) (aws.Config, error) {
+	result, err := getClientConfig("us-east-2", "", "", RoleOptions{}, m, func(configs ...external.Config) (aws.Config, error) {
 		return aws.Config{
 			Region:      "us-east-1",
 			Credentials: dummyCredProvider,
@@ -96,7 +143,7 @@ func TestEcrClient_GetClientConfig_RegionFlagUpdatesConfigRegion(t *testing.T) {
 func TestEcrClient_GetClientConfig_ReturnsErrOnBadConfigLoad(t *testing.T) {
 	m := &mockRoleAssumer{}
 
-	_, err := getClientConfig("us-east-1", "", "", m, func(configs ...external.Config) (aws.Config, error) {
+	_, err := getClientConfig("us-east-1", "", "", RoleOptions{}, m, func(configs ...external.Config) (aws.Config, error) {
 		return aws.Config{}, errors.New("some error")
 	})
 
@@ -106,20 +153,21 @@ func TestEcrClient_GetClientConfig_ReturnsErrOnBadConfigLoad(t *testing.T) {
 func TestEcrClient_GetClientConfig_ReturnsErrNoCredentials(t *testing.T) {
 	m := &mockRoleAssumer{}
 
-	_, err := getClientConfig("us-east-1", "", "", m, func(configs ...external.Config) (aws.Config, error) {
+	_, err := getClientConfig("us-east-1", "", "", RoleOptions{}, m, func(configs ...external.Config) (aws.Config, error) {
 		return aws.Config{
 			Credentials: nil,
 		}, nil
 	})
 
-	require.Equal(t, ErrNoCredentials, err)
+	var noCredsErr *ErrNoCredentials
+	require.ErrorAs(t, err, &noCredsErr)
 }
 
 func TestEcrClient_GetClientConfig_ReturnsErrorOnBadService(t *testing.T) {
 	m := &mockRoleAssumer{}
 	dummyCredProvider := &sts.CredentialsProvider{}
 
-	_, err := getClientConfig("", "", "", m, func(configs ...external.Config) (aws.Config, error) {
+	_, err := getClientConfig("", "", "", RoleOptions{}, m, func(configs ...external.Config) (aws.Config, error) {
 		return aws.Config{
 			Region:      "macho-man-randy-savage",
 			Credentials: dummyCredProvider,
@@ -129,13 +177,211 @@ func TestEcrClient_GetClientConfig_ReturnsErrorOnBadService(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestEcrClient_GetClientConfig_WebIdentityUpdatesNewCredentials(t *testing.T) {
+	m := &mockRoleAssumer{}
+	dummyCredProvider := &sts.CredentialsProvider{}
+	roleOpts := RoleOptions{WebIdentityRoleArn: "arn:aws:iam::123456789012:role/irsa-role", WebIdentityTokenFile: "/var/run/secrets/token"}
+	m.On("AssumeRoleWithWebIdentity", mock.Anything, roleOpts.WebIdentityRoleArn, defaultSessionName, roleOpts.WebIdentityTokenFile).Return(dummyCredProvider, nil)
+
+	result, err := getClientConfig("us-east-1", "", "", roleOpts, m, func(configs ...external.Config) (aws.Config, error) {
+		return aws.Config{
+			Region:      "us-east-1",
+			Credentials: dummyCredProvider,
+		}, nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, dummyCredProvider, result.Credentials)
+	m.AssertNotCalled(t, "AssumeRole", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestEcrClient_GetClientConfig_WebIdentityTakesPrecedenceOverRole(t *testing.T) {
+	m := &mockRoleAssumer{}
+	dummyCredProvider := &sts.CredentialsProvider{}
+	roleOpts := RoleOptions{WebIdentityRoleArn: "arn:aws:iam::123456789012:role/irsa-role", WebIdentityTokenFile: "/var/run/secrets/token"}
+	m.On("AssumeRoleWithWebIdentity", mock.Anything, roleOpts.WebIdentityRoleArn, defaultSessionName, roleOpts.WebIdentityTokenFile).Return(dummyCredProvider, nil)
+
+	_, err := getClientConfig("us-east-1", "some-other-role", "", roleOpts, m, func(configs ...external.Config) (aws.Config, error) {
+		return aws.Config{
+			Region:      "us-east-1",
+			Credentials: dummyCredProvider,
+		}, nil
+	})
+
+	require.NoError(t, err)
+	m.AssertNotCalled(t, "AssumeRole", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestEcrClient_GetClientConfig_ReturnsErrorOnBadWebIdentityAssume(t *testing.T) {
+	m := &mockRoleAssumer{}
+	dummyCredProvider := &sts.CredentialsProvider{}
+	roleOpts := RoleOptions{WebIdentityRoleArn: "arn:aws:iam::123456789012:role/irsa-role", WebIdentityTokenFile: "/var/run/secrets/token"}
+	m.On("AssumeRoleWithWebIdentity", mock.Anything, roleOpts.WebIdentityRoleArn, defaultSessionName, roleOpts.WebIdentityTokenFile).Return(dummyCredProvider, errors.New("some error"))
+
+	_, err := getClientConfig("us-east-1", "", "", roleOpts, m, func(configs ...external.Config) (aws.Config, error) {
+		return aws.Config{
+			Region:      "us-east-1",
+			Credentials: dummyCredProvider,
+		}, nil
+	})
+
+	var assumeErr *ErrAssumeRoleFailed
+	require.ErrorAs(t, err, &assumeErr)
+	require.Equal(t, roleOpts.WebIdentityRoleArn, assumeErr.RoleArn)
+	require.EqualError(t, assumeErr.Err, "some error")
+}
+
+func TestEcrClient_GetClientConfig_MFARoleOptionsPassedToAssumeRole(t *testing.T) {
+	m := &mockRoleAssumer{}
+	dummyCredProvider := &sts.CredentialsProvider{}
+	roleOpts := RoleOptions{MFASerial: "arn:aws:iam::123456789012:mfa/me", MFATokenProvider: func() (string, error) { return "123456", nil }}
+	m.On("AssumeRole", mock.Anything, "testing", mock.MatchedBy(func(opts RoleOptions) bool {
+		return opts.MFASerial == roleOpts.MFASerial && opts.MFATokenProvider != nil
+	})).Return(dummyCredProvider, nil)
+
+	result, err := getClientConfig("us-east-1", "testing", "", roleOpts, m, func(configs ...external.Config) (aws.Config, error) {
+		return aws.Config{
+			Region:      "us-east-1",
+			Credentials: dummyCredProvider,
+		}, nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, dummyCredProvider, result.Credentials)
+}
+
+func TestEcrClient_GetClientConfig_ReturnsErrorOnBadMFAAssumeRole(t *testing.T) {
+	m := &mockRoleAssumer{}
+	dummyCredProvider := &sts.CredentialsProvider{}
+	roleOpts := RoleOptions{MFASerial: "arn:aws:iam::123456789012:mfa/me", MFATokenProvider: func() (string, error) { return "000000", nil }}
+	m.On("AssumeRole", mock.Anything, "testing", mock.MatchedBy(func(opts RoleOptions) bool {
+		return opts.MFASerial == roleOpts.MFASerial && opts.MFATokenProvider != nil
+	})).Return(dummyCredProvider, sts.ErrMFARequired)
+
+	_, err := getClientConfig("us-east-1", "testing", "", roleOpts, m, func(configs ...external.Config) (aws.Config, error) {
+		return aws.Config{
+			Region:      "us-east-1",
+			Credentials: dummyCredProvider,
+		}, nil
+	})
+
+	require.True(t, errors.Is(err, ErrMFARequired))
+}
+
+func TestEcrClient_DefaultRoleAssumer_AssumeRole_AppliesMFAAndSessionDuration(t *testing.T) {
+	tokenCalls := 0
+	roleOpts := RoleOptions{
+		MFASerial:        "arn:aws:iam::123456789012:mfa/me",
+		MFATokenProvider: func() (string, error) { tokenCalls++; return "123456", nil },
+		SessionDuration:  30 * time.Minute,
+	}
+
+	provider, err := defaultRoleAssumer{}.AssumeRole(aws.Config{Region: "us-east-1"}, "testing", roleOpts)
+
+	require.NoError(t, err)
+	require.Equal(t, roleOpts.MFASerial, provider.MFASerial)
+	require.Equal(t, roleOpts.SessionDuration, provider.SessionDuration)
+	require.Equal(t, 0, tokenCalls, "AssumeRole should build the provider without invoking the token provider itself")
+}
+
+func TestEcrClient_DefaultRoleAssumer_AssumeRoleWithWebIdentity_BuildsProvider(t *testing.T) {
+	provider, err := defaultRoleAssumer{}.AssumeRoleWithWebIdentity(aws.Config{Region: "us-east-1"}, "arn:aws:iam::123456789012:role/irsa-role", "", "/var/run/secrets/token")
+
+	require.NoError(t, err)
+	require.Equal(t, "arn:aws:iam::123456789012:role/irsa-role", provider.RoleArn)
+	require.Equal(t, defaultSessionName, provider.SessionName, "an empty sessionName should fall back to defaultSessionName")
+	require.Equal(t, "/var/run/secrets/token", provider.WebIdentityTokenFilePath)
+}
+
+func TestEcrClient_GetClientConfig_SSOProfileCacheHit(t *testing.T) {
+	path := createProfile("tmp-sso-profile", "[profile my-sso-profile]\nsso_account_id = 123456789012\nsso_role_name = MyRole\nsso_start_url = https://my-sso-portal.awsapps.com/start\nsso_region = us-east-1\n")
+	defer os.Remove(path)
+	defer os.Unsetenv("AWS_CONFIG_FILE")
+
+	home := t.TempDir()
+	restoreHome := setHomeDir(t, home)
+	defer restoreHome()
+	writeSSOCacheToken(t, home, "https://my-sso-portal.awsapps.com/start", "a-valid-token", time.Now().Add(time.Hour))
+
+	m := &mockRoleAssumer{}
+
+	result, err := getClientConfig("us-east-1", "", "my-sso-profile", RoleOptions{}, m, external.LoadDefaultAWSConfig)
+
+	require.NoError(t, err)
+	require.IsType(t, &ssoCredentialsProvider{}, result.Credentials)
+}
+
+func TestEcrClient_GetClientConfig_SSOProfileCacheMiss(t *testing.T) {
+	path := createProfile("tmp-sso-profile-miss", "[profile my-sso-profile]\nsso_account_id = 123456789012\nsso_role_name = MyRole\nsso_start_url = https://my-sso-portal.awsapps.com/start\nsso_region = us-east-1\n")
+	defer os.Remove(path)
+	defer os.Unsetenv("AWS_CONFIG_FILE")
+
+	home := t.TempDir()
+	restoreHome := setHomeDir(t, home)
+	defer restoreHome()
+
+	m := &mockRoleAssumer{}
+
+	result, err := getClientConfig("us-east-1", "", "my-sso-profile", RoleOptions{}, m, external.LoadDefaultAWSConfig)
+
+	require.NoError(t, err)
+	provider, ok := result.Credentials.(*ssoCredentialsProvider)
+	require.True(t, ok)
+
+	_, err = provider.Retrieve()
+	require.Equal(t, ErrSSOLoginRequired, err)
+}
+
+func TestEcrClient_GetClientConfig_SSOProfileExpiredToken(t *testing.T) {
+	path := createProfile("tmp-sso-profile-expired", "[profile my-sso-profile]\nsso_account_id = 123456789012\nsso_role_name = MyRole\nsso_start_url = https://my-sso-portal.awsapps.com/start\nsso_region = us-east-1\n")
+	defer os.Remove(path)
+	defer os.Unsetenv("AWS_CONFIG_FILE")
+
+	home := t.TempDir()
+	restoreHome := setHomeDir(t, home)
+	defer restoreHome()
+	writeSSOCacheToken(t, home, "https://my-sso-portal.awsapps.com/start", "an-expired-token", time.Now().Add(-time.Hour))
+
+	m := &mockRoleAssumer{}
+
+	result, err := getClientConfig("us-east-1", "", "my-sso-profile", RoleOptions{}, m, external.LoadDefaultAWSConfig)
+
+	require.NoError(t, err)
+	provider, ok := result.Credentials.(*ssoCredentialsProvider)
+	require.True(t, ok)
+
+	_, err = provider.Retrieve()
+	require.Equal(t, ErrSSOLoginRequired, err)
+}
+
+func writeSSOCacheToken(t *testing.T, home, startURL, accessToken string, expiresAt time.Time) {
+	sum := sha1.Sum([]byte(startURL))
+	cacheDir := filepath.Join(home, ".aws", "sso", "cache")
+	require.NoError(t, os.MkdirAll(cacheDir, 0755))
+
+	token := fmt.Sprintf(`{"accessToken":%q,"expiresAt":%q}`, accessToken, expiresAt.Format(time.RFC3339))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(cacheDir, hex.EncodeToString(sum[:])+".json"), []byte(token), 0600))
+}
+
+func setHomeDir(t *testing.T, dir string) func() {
+	previous, hadPrevious := os.LookupEnv("HOME")
+	require.NoError(t, os.Setenv("HOME", dir))
+	return func() {
+		if hadPrevious {
+			os.Setenv("HOME", previous)
+		} else {
+			os.Unsetenv("HOME")
+		}
+	}
+}
+
 func TestEcrClient_GetClientConfig_ValidProfile(t *testing.T) {
 	path := createProfile("tmp-profile", "[my-profile]\naws_access_key_id = myaccesskey\naws_secret_access_key = mysecretaccesskey")
 	defer os.Remove(path)
 	defer os.Unsetenv("AWS_CONFIG_FILE")
 	m := &mockRoleAssumer{}
 
-	result, err := getClientConfig("us-east-1", "", "my-profile", m, external.LoadDefaultAWSConfig)
+	result, err := getClientConfig("us-east-1", "", "my-profile", RoleOptions{}, m, external.LoadDefaultAWSConfig)
 
 	sharedConfigSource := false
 	for _, source := range result.ConfigSources {
@@ -152,7 +398,7 @@ func TestEcrClient_GetClientConfig_ValidProfile(t *testing.T) {
 func TestEcrClient_GetClientConfig_BadProfile(t *testing.T) {
 	m := &mockRoleAssumer{}
 
-	result, err := getClientConfig("us-east-1", "", "not-a-profile", m, external.LoadDefaultAWSConfig)
+	result, err := getClientConfig("us-east-1", "", "not-a-profile", RoleOptions{}, m, external.LoadDefaultAWSConfig)
 
 	sharedConfigSource := false
 	for _, source := range result.ConfigSources {
@@ -167,13 +413,13 @@ func TestEcrClient_GetClientConfig_BadProfile(t *testing.T) {
 }
 
 func TestEcrClient_NewClient_ReturnsValidClient(t *testing.T) {
-	_, err := NewClient("us-east-1", "", "")
+	_, err := NewClient("us-east-1", "", "", RoleOptions{})
 
 	assert.NoError(t, err)
 }
 
 func TestEcrClient_NewClient_ReturnsErrorForBadConfig(t *testing.T) {
-	_, err := NewClient("macho-man-randy-savage", "", "")
+	_, err := NewClient("macho-man-randy-savage", "", "", RoleOptions{})
 
 	require.Error(t, err)
 }
@@ -198,17 +444,149 @@ func TestEcrClient_ExtractToken_ReturnsInvalidTokenErrorOnWrongNumberOfParts(t *
 	require.EqualError(t, err, fmt.Sprintf("invalid token: expected two parts, got %d", 1))
 }
 
+func TestGetAuthorizationTokensFor_FallsThroughToSingleAccountWhenEmpty(t *testing.T) {
+	single := &RegistryAuth{Username: "AWS", Password: "own-account-secret"}
+	fetchCalls := 0
+
+	result, err := getAuthorizationTokensFor(
+		nil,
+		func() (*RegistryAuth, error) { return single, nil },
+		func(registryID string) (*RegistryAuth, error) { fetchCalls++; return nil, nil },
+	)
+
+	require.NoError(t, err)
+	require.Equal(t, []RegistryAuth{*single}, result)
+	require.Zero(t, fetchCalls)
+}
+
+func TestGetAuthorizationTokensFor_ReturnsErrorWhenSingleAccountFails(t *testing.T) {
+	result, err := getAuthorizationTokensFor(
+		nil,
+		func() (*RegistryAuth, error) { return nil, errors.New("access denied") },
+		func(registryID string) (*RegistryAuth, error) { return nil, nil },
+	)
+
+	require.EqualError(t, err, "access denied")
+	require.Nil(t, result)
+}
+
+func TestGetAuthorizationTokensFor_ResolvesOwnAccountPlusOneTokenPerRegistry(t *testing.T) {
+	own := &RegistryAuth{Username: "AWS", Password: "own-account-secret"}
+
+	result, err := getAuthorizationTokensFor(
+		[]string{"111111111111", "222222222222"},
+		func() (*RegistryAuth, error) { return own, nil },
+		func(registryID string) (*RegistryAuth, error) {
+			return &RegistryAuth{Username: "AWS", Password: registryID + "-secret"}, nil
+		},
+	)
+
+	require.NoError(t, err)
+	require.Equal(t, []RegistryAuth{
+		*own,
+		{Username: "AWS", Password: "111111111111-secret"},
+		{Username: "AWS", Password: "222222222222-secret"},
+	}, result)
+}
+
+func TestGetAuthorizationTokensFor_ReturnsErrorWhenOwnAccountFailsWithRegistryIDs(t *testing.T) {
+	fetchCalls := 0
+
+	result, err := getAuthorizationTokensFor(
+		[]string{"111111111111"},
+		func() (*RegistryAuth, error) { return nil, errors.New("access denied") },
+		func(registryID string) (*RegistryAuth, error) { fetchCalls++; return nil, nil },
+	)
+
+	require.EqualError(t, err, "access denied")
+	require.Nil(t, result)
+	require.Zero(t, fetchCalls)
+}
+
+func TestGetAuthorizationTokensFor_ReturnsErrorOnPartialFailure(t *testing.T) {
+	own := &RegistryAuth{Username: "AWS", Password: "own-account-secret"}
+	fetched := []string{}
+
+	result, err := getAuthorizationTokensFor(
+		[]string{"111111111111", "222222222222", "333333333333"},
+		func() (*RegistryAuth, error) { return own, nil },
+		func(registryID string) (*RegistryAuth, error) {
+			fetched = append(fetched, registryID)
+			if registryID == "222222222222" {
+				return nil, errors.New("access denied")
+			}
+			return &RegistryAuth{Username: "AWS", Password: registryID + "-secret"}, nil
+		},
+	)
+
+	require.EqualError(t, err, "registry 222222222222: access denied")
+	require.Nil(t, result)
+	require.Equal(t, []string{"111111111111", "222222222222"}, fetched)
+}
+
+func TestEcrClient_FetchAuthorizationTokenForRegistry_ErrorsWithoutACrossAccountRoleName(t *testing.T) {
+	c := &ecrClient{}
+
+	_, err := c.fetchAuthorizationTokenForRegistry("222222222222")
+
+	require.Error(t, err, "without --role there's no role name to assume in the target account")
+}
+
+func TestEcrClient_FetchAuthorizationTokenForRegistry_PreservesPartitionAndRoleOptions(t *testing.T) {
+	m := &mockRoleAssumer{}
+	roleOpts := RoleOptions{SessionDuration: 30 * time.Minute}
+	m.On("AssumeRole", mock.Anything, "arn:aws-us-gov:iam::222222222222:role/deploy", roleOpts).
+		Return((*sts.CredentialsProvider)(nil), errors.New("boom"))
+
+	crossAccountRole, err := arn.Parse("arn:aws-us-gov:iam::111111111111:role/deploy")
+	require.NoError(t, err)
+
+	c := &ecrClient{
+		assumer:             m,
+		roleOpts:            roleOpts,
+		crossAccountRole:    crossAccountRole,
+		hasCrossAccountRole: true,
+	}
+
+	_, fetchErr := c.fetchAuthorizationTokenForRegistry("222222222222")
+
+	var assumeErr *ErrAssumeRoleFailed
+	require.ErrorAs(t, fetchErr, &assumeErr)
+	require.Equal(t, "arn:aws-us-gov:iam::222222222222:role/deploy", assumeErr.RoleArn)
+	m.AssertExpectations(t)
+}
+
 func TestEcrClient_SetupRepository_ReturnsValidRepositoryWhenNotExists(t *testing.T) {
 	m := mockECRClient{}
 	m.On("RepositoryExists", mock.Anything).Return(false, nil)
-	m.On("CreateRepository", mock.Anything).Return(nil)
+	m.On("CreateRepository", mock.Anything, mock.Anything).Return(nil)
+	m.On("GetRepositoryURI", mock.Anything).Return("someurl", nil)
+
+	result, err := SetupRepository(&m, "myrepository", RepositoryConfig{})
+
+	require.NoError(t, err)
+	require.Equal(t, "someurl", result)
+	require.Equal(t, true, m.AssertCalled(t, "CreateRepository", "myrepository", RepositoryConfig{}))
+}
+
+func TestEcrClient_SetupRepository_CreatesRepositoryWithFullConfig(t *testing.T) {
+	config := RepositoryConfig{
+		ImageTagMutability:  ImageTagMutabilityImmutable,
+		ScanOnPush:          true,
+		Tags:                []Tag{{Key: "team", Value: "platform"}},
+		LifecyclePolicyText: `{"rules":[]}`,
+	}
+
+	m := mockECRClient{}
+	m.On("RepositoryExists", mock.Anything).Return(false, nil)
+	m.On("CreateRepository", "myrepository", config).Return(nil)
 	m.On("GetRepositoryURI", mock.Anything).Return("someurl", nil)
 
-	result, err := SetupRepository(&m, "myrepository")
+	result, err := SetupRepository(&m, "myrepository", config)
 
 	require.NoError(t, err)
 	require.Equal(t, "someurl", result)
-	require.Equal(t, true, m.AssertCalled(t, "CreateRepository", "myrepository"))
+	m.AssertCalled(t, "CreateRepository", "myrepository", config)
 }
 
 func TestEcrClient_SetupRepository_DoesNotCreateRepositoryWhenRepositoryExists(t *testing.T) {
@@ -216,18 +594,62 @@ func TestEcrClient_SetupRepository_DoesNotCreateRepositoryWhenRepositoryExists(t
 	m.On("RepositoryExists", mock.Anything).Return(true, nil)
 	m.On("GetRepositoryURI", mock.Anything).Return("someurl", nil)
 
-	result, err := SetupRepository(&m, "myrepository")
+	result, err := SetupRepository(&m, "myrepository", RepositoryConfig{})
+
+	m.AssertNotCalled(t, "CreateRepository", mock.Anything, mock.Anything)
+	require.NoError(t, err)
+	require.Equal(t, "someurl", result)
+}
+
+func TestEcrClient_SetupRepository_ReconcilesDriftWhenRepositoryExists(t *testing.T) {
+	current := &RepositoryConfig{
+		ImageTagMutability: ImageTagMutabilityMutable,
+		ScanOnPush:         false,
+		Tags:               []Tag{{Key: "stale", Value: "yes"}},
+	}
+	desired := RepositoryConfig{
+		ImageTagMutability:  ImageTagMutabilityImmutable,
+		ScanOnPush:          true,
+		Tags:                []Tag{{Key: "team", Value: "platform"}},
+		LifecyclePolicyText: `{"rules":[]}`,
+	}
+
+	m := mockECRClient{}
+	m.On("RepositoryExists", mock.Anything).Return(true, nil)
+	m.On("GetRepositoryConfig", "myrepository").Return(current, nil)
+	m.On("PutImageTagMutability", "myrepository", ImageTagMutabilityImmutable).Return(nil)
+	m.On("PutImageScanningConfiguration", "myrepository", true).Return(nil)
+	m.On("SetTags", "myrepository", desired.Tags).Return(nil)
+	m.On("PutLifecyclePolicy", "myrepository", desired.LifecyclePolicyText).Return(nil)
+	m.On("GetRepositoryURI", mock.Anything).Return("someurl", nil)
+
+	result, err := SetupRepository(&m, "myrepository", desired)
+
+	require.NoError(t, err)
+	require.Equal(t, "someurl", result)
+	m.AssertCalled(t, "PutImageTagMutability", "myrepository", ImageTagMutabilityImmutable)
+	m.AssertCalled(t, "PutImageScanningConfiguration", "myrepository", true)
+	m.AssertCalled(t, "SetTags", "myrepository", desired.Tags)
+	m.AssertCalled(t, "PutLifecyclePolicy", "myrepository", desired.LifecyclePolicyText)
+}
+
+func TestEcrClient_SetupRepository_SkipsReconciliationForZeroConfig(t *testing.T) {
+	m := mockECRClient{}
+	m.On("RepositoryExists", mock.Anything).Return(true, nil)
+	m.On("GetRepositoryURI", mock.Anything).Return("someurl", nil)
+
+	result, err := SetupRepository(&m, "myrepository", RepositoryConfig{})
 
-	m.AssertNotCalled(t, "CreateRepository")
 	require.NoError(t, err)
 	require.Equal(t, "someurl", result)
+	m.AssertNotCalled(t, "GetRepositoryConfig", mock.Anything)
 }
 
 func TestEcrClient_SetupRepository_ReturnsErrorOnRepositoryExistsError(t *testing.T) {
 	m := mockECRClient{}
 	m.On("RepositoryExists", mock.Anything).Return(false, errors.New("error"))
 
-	result, err := SetupRepository(&m, "myrepository")
+	result, err := SetupRepository(&m, "myrepository", RepositoryConfig{})
 
 	require.EqualError(t, err, "error")
 	require.Empty(t, result)
@@ -236,9 +658,9 @@ func TestEcrClient_SetupRepository_ReturnsErrorOnRepositoryExistsError(t *testin
 func TestEcrClient_SetupRepository_ReturnsErrorOnCreateRepositoryExistsError(t *testing.T) {
 	m := mockECRClient{}
 	m.On("RepositoryExists", mock.Anything).Return(false, nil)
-	m.On("CreateRepository", mock.Anything).Return(errors.New("error"))
+	m.On("CreateRepository", mock.Anything, mock.Anything).Return(errors.New("error"))
 
-	result, err := SetupRepository(&m, "myrepository")
+	result, err := SetupRepository(&m, "myrepository", RepositoryConfig{})
 
 	require.EqualError(t, err, "error")
 	require.Empty(t, result)
@@ -249,7 +671,70 @@ func TestEcrClient_SetupRepository_ReturnsErrorOnGetRepositoryURIError(t *testin
 	m.On("RepositoryExists", mock.Anything).Return(true, nil)
 	m.On("GetRepositoryURI", mock.Anything).Return("", errors.New("error"))
 
-	result, err := SetupRepository(&m, "myrepository")
+	result, err := SetupRepository(&m, "myrepository", RepositoryConfig{})
+
+	require.EqualError(t, err, "error")
+	require.Empty(t, result)
+}
+
+func TestEcrClient_SetupRepository_ReturnsErrorOnGetRepositoryConfigError(t *testing.T) {
+	m := mockECRClient{}
+	m.On("RepositoryExists", mock.Anything).Return(true, nil)
+	m.On("GetRepositoryConfig", mock.Anything).Return((*RepositoryConfig)(nil), errors.New("error"))
+
+	result, err := SetupRepository(&m, "myrepository", RepositoryConfig{ScanOnPush: true})
+
+	require.EqualError(t, err, "error")
+	require.Empty(t, result)
+}
+
+func TestEcrClient_SetupRepository_ReturnsErrorOnPutImageTagMutabilityError(t *testing.T) {
+	current := &RepositoryConfig{ImageTagMutability: ImageTagMutabilityMutable}
+	m := mockECRClient{}
+	m.On("RepositoryExists", mock.Anything).Return(true, nil)
+	m.On("GetRepositoryConfig", mock.Anything).Return(current, nil)
+	m.On("PutImageTagMutability", mock.Anything, mock.Anything).Return(errors.New("error"))
+
+	result, err := SetupRepository(&m, "myrepository", RepositoryConfig{ImageTagMutability: ImageTagMutabilityImmutable})
+
+	require.EqualError(t, err, "error")
+	require.Empty(t, result)
+}
+
+func TestEcrClient_SetupRepository_ReturnsErrorOnPutImageScanningConfigurationError(t *testing.T) {
+	current := &RepositoryConfig{ScanOnPush: false}
+	m := mockECRClient{}
+	m.On("RepositoryExists", mock.Anything).Return(true, nil)
+	m.On("GetRepositoryConfig", mock.Anything).Return(current, nil)
+	m.On("PutImageScanningConfiguration", mock.Anything, mock.Anything).Return(errors.New("error"))
+
+	result, err := SetupRepository(&m, "myrepository", RepositoryConfig{ScanOnPush: true})
+
+	require.EqualError(t, err, "error")
+	require.Empty(t, result)
+}
+
+func TestEcrClient_SetupRepository_ReturnsErrorOnSetTagsError(t *testing.T) {
+	current := &RepositoryConfig{}
+	m := mockECRClient{}
+	m.On("RepositoryExists", mock.Anything).Return(true, nil)
+	m.On("GetRepositoryConfig", mock.Anything).Return(current, nil)
+	m.On("SetTags", mock.Anything, mock.Anything).Return(errors.New("error"))
+
+	result, err := SetupRepository(&m, "myrepository", RepositoryConfig{Tags: []Tag{{Key: "team", Value: "platform"}}})
+
+	require.EqualError(t, err, "error")
+	require.Empty(t, result)
+}
+
+func TestEcrClient_SetupRepository_ReturnsErrorOnPutLifecyclePolicyError(t *testing.T) {
+	current := &RepositoryConfig{}
+	m := mockECRClient{}
+	m.On("RepositoryExists", mock.Anything).Return(true, nil)
+	m.On("GetRepositoryConfig", mock.Anything).Return(current, nil)
+	m.On("PutLifecyclePolicy", mock.Anything, mock.Anything).Return(errors.New("error"))
+
+	result, err := SetupRepository(&m, "myrepository", RepositoryConfig{LifecyclePolicyText: `{"rules":[]}`})
 
 	require.EqualError(t, err, "error")
 	require.Empty(t, result)
@@ -0,0 +1,137 @@
+package ecr
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+// This file is the ecr package's typed error taxonomy. Each type wraps the underlying AWS
+// SDK error (if any) via Unwrap and carries the contextual fields (repository name, role
+// ARN, region) that the bare errors.New/raw awserr.Error this package used to return left
+// callers to parse out of a message string. Each also implements Is so callers can match by
+// type regardless of field values, e.g. errors.Is(err, &ErrRepositoryNotFound{}).
+
+// ErrNoCredentials is returned when the resolved AWS configuration has no usable
+// credentials at all (no static keys, no profile, no environment, no instance role).
+type ErrNoCredentials struct {
+	Profile string
+	Region  string
+}
+
+func (e *ErrNoCredentials) Error() string {
+	return fmt.Sprintf("no credentials found for the configured profile/region (profile=%q region=%q)", e.Profile, e.Region)
+}
+
+func (e *ErrNoCredentials) Is(target error) bool {
+	_, ok := target.(*ErrNoCredentials)
+	return ok
+}
+
+// ErrRegionRequired is returned when no usable AWS region could be resolved, so trebuchet
+// has no ECR endpoint to call.
+type ErrRegionRequired struct {
+	Region string
+	Err    error
+}
+
+func (e *ErrRegionRequired) Error() string {
+	if e.Region == "" {
+		return "no AWS region configured: pass --region or set one in the AWS config/profile"
+	}
+	return fmt.Sprintf("invalid AWS region %q: %v", e.Region, e.Err)
+}
+
+func (e *ErrRegionRequired) Unwrap() error { return e.Err }
+
+func (e *ErrRegionRequired) Is(target error) bool {
+	_, ok := target.(*ErrRegionRequired)
+	return ok
+}
+
+// ErrAssumeRoleFailed is returned when assuming RoleArn fails, whether via AssumeRole or
+// AssumeRoleWithWebIdentity. Err is the underlying error (e.g. sts.ErrMFARequired, or an
+// awserr.Error with code AccessDeniedException/ExpiredTokenException); callers can
+// errors.Is/errors.As through it to react to the specific underlying cause.
+type ErrAssumeRoleFailed struct {
+	RoleArn string
+	Err     error
+}
+
+func (e *ErrAssumeRoleFailed) Error() string {
+	return fmt.Sprintf("assuming role %s: %v", e.RoleArn, e.Err)
+}
+
+func (e *ErrAssumeRoleFailed) Unwrap() error { return e.Err }
+
+func (e *ErrAssumeRoleFailed) Is(target error) bool {
+	_, ok := target.(*ErrAssumeRoleFailed)
+	return ok
+}
+
+// ErrRepositoryNotFound is returned when an operation targets a repository that doesn't
+// exist.
+type ErrRepositoryNotFound struct {
+	Repository string
+	Err        error
+}
+
+func (e *ErrRepositoryNotFound) Error() string {
+	return fmt.Sprintf("repository %s not found", e.Repository)
+}
+
+func (e *ErrRepositoryNotFound) Unwrap() error { return e.Err }
+
+func (e *ErrRepositoryNotFound) Is(target error) bool {
+	_, ok := target.(*ErrRepositoryNotFound)
+	return ok
+}
+
+// ErrRepositoryAccessDenied is returned when the caller's credentials are valid but lack
+// permission for the requested repository operation.
+type ErrRepositoryAccessDenied struct {
+	Repository string
+	Err        error
+}
+
+func (e *ErrRepositoryAccessDenied) Error() string {
+	return fmt.Sprintf("access denied for repository %s: %v", e.Repository, e.Err)
+}
+
+func (e *ErrRepositoryAccessDenied) Unwrap() error { return e.Err }
+
+func (e *ErrRepositoryAccessDenied) Is(target error) bool {
+	_, ok := target.(*ErrRepositoryAccessDenied)
+	return ok
+}
+
+// ErrInvalidToken is returned when an ECR authorization token can't be decoded into a
+// username/password pair.
+type ErrInvalidToken struct {
+	Err error
+}
+
+func (e *ErrInvalidToken) Error() string {
+	return fmt.Sprintf("invalid token: %v", e.Err)
+}
+
+func (e *ErrInvalidToken) Unwrap() error { return e.Err }
+
+func (e *ErrInvalidToken) Is(target error) bool {
+	_, ok := target.(*ErrInvalidToken)
+	return ok
+}
+
+// wrapRepositoryError maps err's AWS error code to this package's typed repository errors,
+// so callers can react via errors.Is/errors.As instead of string-matching. err is returned
+// unchanged if its code isn't one this package gives a typed wrapper for.
+func wrapRepositoryError(repository string, err error) error {
+	switch {
+	case isAWSErrorCode(err, ecr.ErrCodeRepositoryNotFoundException):
+		return &ErrRepositoryNotFound{Repository: repository, Err: err}
+	case isAWSErrorCode(err, "AccessDeniedException"):
+		return &ErrRepositoryAccessDenied{Repository: repository, Err: err}
+	default:
+		return err
+	}
+}
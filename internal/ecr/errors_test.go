@@ -0,0 +1,100 @@
+package ecr
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrNoCredentials_MatchesViaErrorsIs(t *testing.T) {
+	var err error = &ErrNoCredentials{Profile: "my-profile", Region: "us-east-1"}
+
+	require.True(t, errors.Is(err, &ErrNoCredentials{}))
+
+	var target *ErrNoCredentials
+	require.True(t, errors.As(err, &target))
+	require.Equal(t, "my-profile", target.Profile)
+}
+
+func TestErrRegionRequired_MatchesViaErrorsIs(t *testing.T) {
+	inner := errors.New("invalid region")
+	var err error = &ErrRegionRequired{Region: "bogus", Err: inner}
+
+	require.True(t, errors.Is(err, &ErrRegionRequired{}))
+	require.True(t, errors.Is(err, inner))
+
+	var target *ErrRegionRequired
+	require.True(t, errors.As(err, &target))
+	require.Equal(t, "bogus", target.Region)
+}
+
+func TestErrAssumeRoleFailed_UnwrapsToMFARequired(t *testing.T) {
+	var err error = &ErrAssumeRoleFailed{RoleArn: "arn:aws:iam::123456789012:role/ci", Err: ErrMFARequired}
+
+	require.True(t, errors.Is(err, ErrMFARequired))
+
+	var target *ErrAssumeRoleFailed
+	require.True(t, errors.As(err, &target))
+	require.Equal(t, "arn:aws:iam::123456789012:role/ci", target.RoleArn)
+}
+
+func TestErrRepositoryNotFound_MatchesViaErrorsIs(t *testing.T) {
+	var err error = &ErrRepositoryNotFound{Repository: "myrepository"}
+
+	require.True(t, errors.Is(err, &ErrRepositoryNotFound{}))
+
+	var target *ErrRepositoryNotFound
+	require.True(t, errors.As(err, &target))
+	require.Equal(t, "myrepository", target.Repository)
+}
+
+func TestErrRepositoryAccessDenied_MatchesViaErrorsIs(t *testing.T) {
+	inner := errors.New("AccessDeniedException: user is not authorized")
+	var err error = &ErrRepositoryAccessDenied{Repository: "myrepository", Err: inner}
+
+	require.True(t, errors.Is(err, &ErrRepositoryAccessDenied{}))
+	require.True(t, errors.Is(err, inner))
+
+	var target *ErrRepositoryAccessDenied
+	require.True(t, errors.As(err, &target))
+	require.Equal(t, "myrepository", target.Repository)
+}
+
+func TestErrInvalidToken_MatchesViaErrorsIs(t *testing.T) {
+	inner := errors.New("illegal base64 data")
+	var err error = &ErrInvalidToken{Err: inner}
+
+	require.True(t, errors.Is(err, &ErrInvalidToken{}))
+	require.True(t, errors.Is(err, inner))
+
+	var target *ErrInvalidToken
+	require.True(t, errors.As(err, &target))
+}
+
+func TestWrapRepositoryError_ReturnsErrRepositoryAccessDeniedForAccessDeniedCode(t *testing.T) {
+	err := wrapRepositoryError("myrepository", &fakeAWSError{code: "AccessDeniedException"})
+
+	var target *ErrRepositoryAccessDenied
+	require.True(t, errors.As(err, &target))
+	require.Equal(t, "myrepository", target.Repository)
+}
+
+func TestWrapRepositoryError_PassesThroughUnrecognizedCodes(t *testing.T) {
+	inner := &fakeAWSError{code: "ThrottlingException"}
+
+	err := wrapRepositoryError("myrepository", inner)
+
+	require.Equal(t, inner, err)
+}
+
+// fakeAWSError is a minimal awserr.Error stand-in so wrapRepositoryError can be tested
+// without having to provoke a real AWS SDK error code.
+type fakeAWSError struct {
+	code string
+}
+
+func (e *fakeAWSError) Error() string   { return e.code }
+func (e *fakeAWSError) Code() string    { return e.code }
+func (e *fakeAWSError) Message() string { return e.code }
+func (e *fakeAWSError) OrigErr() error  { return nil }
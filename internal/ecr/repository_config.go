@@ -0,0 +1,224 @@
+package ecr
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+// ImageTagMutability mirrors the ECR ImageTagMutability enum.
+type ImageTagMutability string
+
+const (
+	ImageTagMutabilityMutable   ImageTagMutability = "MUTABLE"
+	ImageTagMutabilityImmutable ImageTagMutability = "IMMUTABLE"
+)
+
+// Tag is a single repository tag.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// RepositoryConfig declares the desired state of an ECR repository: how its tags may
+// mutate, whether images are scanned on push, the tags applied to it, and an optional
+// lifecycle policy. SetupRepository reconciles an existing repository toward this state
+// rather than silently ignoring it.
+type RepositoryConfig struct {
+	ImageTagMutability  ImageTagMutability
+	ScanOnPush          bool
+	Tags                []Tag
+	LifecyclePolicyText string
+}
+
+func (c *ecrClient) CreateRepository(repository string, config RepositoryConfig) error {
+	input := &ecr.CreateRepositoryInput{
+		RepositoryName: aws.String(repository),
+		ImageScanningConfiguration: &ecr.ImageScanningConfiguration{
+			ScanOnPush: aws.Bool(config.ScanOnPush),
+		},
+	}
+
+	if config.ImageTagMutability != "" {
+		input.ImageTagMutability = ecr.ImageTagMutability(config.ImageTagMutability)
+	}
+
+	for _, tag := range config.Tags {
+		input.Tags = append(input.Tags, ecr.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)})
+	}
+
+	req := c.client.CreateRepositoryRequest(input)
+	_, err := req.Send(context.Background())
+	if err != nil {
+		return wrapRepositoryError(repository, err)
+	}
+
+	if config.LifecyclePolicyText != "" {
+		return c.PutLifecyclePolicy(repository, config.LifecyclePolicyText)
+	}
+
+	return nil
+}
+
+// GetRepositoryConfig reads back a repository's current mutability, scan-on-push, tags,
+// and lifecycle policy, so SetupRepository can diff it against the desired RepositoryConfig.
+func (c *ecrClient) GetRepositoryConfig(repository string) (*RepositoryConfig, error) {
+	describeReq := c.client.DescribeRepositoriesRequest(&ecr.DescribeRepositoriesInput{
+		RepositoryNames: []string{repository},
+	})
+
+	describeResp, err := describeReq.Send(context.Background())
+	if err != nil {
+		return nil, wrapRepositoryError(repository, err)
+	}
+
+	if len(describeResp.Repositories) == 0 {
+		return nil, &ErrRepositoryNotFound{Repository: repository}
+	}
+
+	repo := describeResp.Repositories[0]
+	config := &RepositoryConfig{
+		ImageTagMutability: ImageTagMutability(repo.ImageTagMutability),
+	}
+
+	if repo.ImageScanningConfiguration != nil {
+		config.ScanOnPush = aws.BoolValue(repo.ImageScanningConfiguration.ScanOnPush)
+	}
+
+	tagsReq := c.client.ListTagsForResourceRequest(&ecr.ListTagsForResourceInput{
+		ResourceArn: repo.RepositoryArn,
+	})
+
+	tagsResp, err := tagsReq.Send(context.Background())
+	if err != nil {
+		return nil, wrapRepositoryError(repository, err)
+	}
+
+	for _, tag := range tagsResp.Tags {
+		config.Tags = append(config.Tags, Tag{Key: aws.StringValue(tag.Key), Value: aws.StringValue(tag.Value)})
+	}
+
+	policyReq := c.client.GetLifecyclePolicyRequest(&ecr.GetLifecyclePolicyInput{
+		RepositoryName: aws.String(repository),
+	})
+
+	policyResp, err := policyReq.Send(context.Background())
+	if err != nil {
+		if !isAWSErrorCode(err, ecr.ErrCodeLifecyclePolicyNotFoundException) {
+			return nil, wrapRepositoryError(repository, err)
+		}
+	} else {
+		config.LifecyclePolicyText = aws.StringValue(policyResp.LifecyclePolicyText)
+	}
+
+	return config, nil
+}
+
+func (c *ecrClient) PutImageTagMutability(repository string, mutability ImageTagMutability) error {
+	req := c.client.PutImageTagMutabilityRequest(&ecr.PutImageTagMutabilityInput{
+		RepositoryName:     aws.String(repository),
+		ImageTagMutability: ecr.ImageTagMutability(mutability),
+	})
+
+	_, err := req.Send(context.Background())
+	if err != nil {
+		return wrapRepositoryError(repository, err)
+	}
+	return nil
+}
+
+func (c *ecrClient) PutImageScanningConfiguration(repository string, scanOnPush bool) error {
+	req := c.client.PutImageScanningConfigurationRequest(&ecr.PutImageScanningConfigurationInput{
+		RepositoryName: aws.String(repository),
+		ImageScanningConfiguration: &ecr.ImageScanningConfiguration{
+			ScanOnPush: aws.Bool(scanOnPush),
+		},
+	})
+
+	_, err := req.Send(context.Background())
+	if err != nil {
+		return wrapRepositoryError(repository, err)
+	}
+	return nil
+}
+
+func (c *ecrClient) PutLifecyclePolicy(repository, policyText string) error {
+	req := c.client.PutLifecyclePolicyRequest(&ecr.PutLifecyclePolicyInput{
+		RepositoryName:      aws.String(repository),
+		LifecyclePolicyText: aws.String(policyText),
+	})
+
+	_, err := req.Send(context.Background())
+	if err != nil {
+		return wrapRepositoryError(repository, err)
+	}
+	return nil
+}
+
+// SetTags reconciles a repository's tags to exactly match desired: every tag in desired is
+// applied via TagResource (adding missing keys and overwriting changed values), and tags
+// present on the repository but absent from desired are removed via UntagResource.
+func (c *ecrClient) SetTags(repository string, desired []Tag) error {
+	describeReq := c.client.DescribeRepositoriesRequest(&ecr.DescribeRepositoriesInput{
+		RepositoryNames: []string{repository},
+	})
+
+	describeResp, err := describeReq.Send(context.Background())
+	if err != nil {
+		return wrapRepositoryError(repository, err)
+	}
+
+	if len(describeResp.Repositories) == 0 {
+		return &ErrRepositoryNotFound{Repository: repository}
+	}
+
+	arn := describeResp.Repositories[0].RepositoryArn
+
+	currentReq := c.client.ListTagsForResourceRequest(&ecr.ListTagsForResourceInput{ResourceArn: arn})
+	currentResp, err := currentReq.Send(context.Background())
+	if err != nil {
+		return wrapRepositoryError(repository, err)
+	}
+
+	desiredByKey := make(map[string]string, len(desired))
+	for _, tag := range desired {
+		desiredByKey[tag.Key] = tag.Value
+	}
+
+	var toRemove []string
+	for _, tag := range currentResp.Tags {
+		key := aws.StringValue(tag.Key)
+		if _, wanted := desiredByKey[key]; !wanted {
+			toRemove = append(toRemove, key)
+		}
+	}
+
+	if len(desired) > 0 {
+		var toAdd []ecr.Tag
+		for _, tag := range desired {
+			toAdd = append(toAdd, ecr.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)})
+		}
+
+		tagReq := c.client.TagResourceRequest(&ecr.TagResourceInput{ResourceArn: arn, Tags: toAdd})
+		if _, err := tagReq.Send(context.Background()); err != nil {
+			return wrapRepositoryError(repository, err)
+		}
+	}
+
+	if len(toRemove) > 0 {
+		untagReq := c.client.UntagResourceRequest(&ecr.UntagResourceInput{ResourceArn: arn, TagKeys: toRemove})
+		if _, err := untagReq.Send(context.Background()); err != nil {
+			return wrapRepositoryError(repository, err)
+		}
+	}
+
+	return nil
+}
+
+func isAWSErrorCode(err error, code string) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == code
+}
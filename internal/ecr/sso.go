@@ -0,0 +1,211 @@
+package ecr
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sso"
+	"github.com/go-ini/ini"
+)
+
+// ErrSSOLoginRequired is returned when a profile names an SSO session or account/role but
+// no valid cached access token can be found, meaning the caller needs to run
+// `aws sso login` before trebuchet can obtain ECR credentials.
+var ErrSSOLoginRequired = errors.New("no valid SSO access token cached; run `aws sso login`")
+
+// ssoExpiryWindow is how far ahead of the reported expiration we refresh role credentials.
+const ssoExpiryWindow = 5 * time.Minute
+
+// ssoProfile is the subset of a shared-config SSO profile needed to exchange a cached
+// access token for temporary ECR credentials via sso:GetRoleCredentials.
+type ssoProfile struct {
+	StartURL  string
+	Region    string
+	AccountID string
+	RoleName  string
+
+	// SessionName is set when the profile names a [sso-session NAME] block. The AWS CLI
+	// keys that session's token cache entry by sha1(SessionName) rather than
+	// sha1(StartURL), so readCachedSSOToken must look the token up under SessionName
+	// whenever it's set.
+	SessionName string
+}
+
+// tokenCacheKey returns the value the AWS CLI's SSO token cache is keyed by for this
+// profile: the sso-session name for profiles using the newer [sso-session NAME] block, or
+// the legacy sso_start_url otherwise.
+func (p ssoProfile) tokenCacheKey() string {
+	if p.SessionName != "" {
+		return p.SessionName
+	}
+	return p.StartURL
+}
+
+// ssoProfileFromConfig reads profileName out of the AWS config file (respecting
+// AWS_CONFIG_FILE, same as the shared-config loader) and returns its SSO settings, if any.
+// It supports both the legacy inline sso_* keys and the newer [sso-session NAME] block.
+func ssoProfileFromConfig(profileName string) (*ssoProfile, bool, error) {
+	path := os.Getenv("AWS_CONFIG_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, false, err
+		}
+		path = filepath.Join(home, ".aws", "config")
+	}
+
+	cfg, err := ini.Load(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	section, err := cfg.GetSection("profile " + profileName)
+	if err != nil {
+		section, err = cfg.GetSection(profileName)
+		if err != nil {
+			return nil, false, nil
+		}
+	}
+
+	accountID := section.Key("sso_account_id").String()
+	roleName := section.Key("sso_role_name").String()
+	if accountID == "" || roleName == "" {
+		return nil, false, nil
+	}
+
+	startURL := section.Key("sso_start_url").String()
+	region := section.Key("sso_region").String()
+	sessionName := section.Key("sso_session").String()
+
+	if sessionName != "" {
+		if sessionSection, err := cfg.GetSection("sso-session " + sessionName); err == nil {
+			startURL = sessionSection.Key("sso_start_url").String()
+			region = sessionSection.Key("sso_region").String()
+		}
+	}
+
+	if startURL == "" || region == "" {
+		return nil, false, nil
+	}
+
+	return &ssoProfile{
+		StartURL:    startURL,
+		Region:      region,
+		AccountID:   accountID,
+		RoleName:    roleName,
+		SessionName: sessionName,
+	}, true, nil
+}
+
+// cachedSSOToken is the relevant subset of the JSON blob the AWS CLI writes to
+// ~/.aws/sso/cache/<sha1(cache key)>.json after a successful `aws sso login` (see
+// ssoCacheFilePath for which value the cache key is).
+type cachedSSOToken struct {
+	AccessToken string    `json:"accessToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// ssoCacheFilePath returns the cache file for cacheKey, which is a profile's
+// sso-session name for the newer [sso-session NAME] block format, or its sso_start_url for
+// the legacy inline-key format (see ssoProfile.tokenCacheKey).
+func ssoCacheFilePath(cacheKey string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha1.Sum([]byte(cacheKey))
+	return filepath.Join(home, ".aws", "sso", "cache", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+func readCachedSSOToken(cacheKey string) (*cachedSSOToken, error) {
+	path, err := ssoCacheFilePath(cacheKey)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, ErrSSOLoginRequired
+	}
+
+	var token cachedSSOToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, ErrSSOLoginRequired
+	}
+
+	if token.AccessToken == "" || !time.Now().Before(token.ExpiresAt) {
+		return nil, ErrSSOLoginRequired
+	}
+
+	return &token, nil
+}
+
+// ssoCredentialsProvider is an aws.CredentialsProvider that exchanges a cached SSO access
+// token for temporary ECR credentials via sso:GetRoleCredentials, refreshing once they near
+// expiry.
+type ssoCredentialsProvider struct {
+	client  *sso.Client
+	profile ssoProfile
+
+	expiration  time.Time
+	credentials aws.Credentials
+}
+
+func newSSOCredentialsProvider(cfg aws.Config, profile ssoProfile) *ssoCredentialsProvider {
+	ssoCfg := cfg.Copy()
+	ssoCfg.Region = profile.Region
+
+	return &ssoCredentialsProvider{
+		client:  sso.New(ssoCfg),
+		profile: profile,
+	}
+}
+
+func (p *ssoCredentialsProvider) Retrieve() (aws.Credentials, error) {
+	if p.credentials.AccessKeyID != "" && time.Now().Add(ssoExpiryWindow).Before(p.expiration) {
+		return p.credentials, nil
+	}
+
+	token, err := readCachedSSOToken(p.profile.tokenCacheKey())
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	req := p.client.GetRoleCredentialsRequest(&sso.GetRoleCredentialsInput{
+		AccessToken: aws.String(token.AccessToken),
+		AccountId:   aws.String(p.profile.AccountID),
+		RoleName:    aws.String(p.profile.RoleName),
+	})
+
+	resp, err := req.Send(context.Background())
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	creds := resp.RoleCredentials
+	p.credentials = aws.Credentials{
+		AccessKeyID:     aws.StringValue(creds.AccessKeyId),
+		SecretAccessKey: aws.StringValue(creds.SecretAccessKey),
+		SessionToken:    aws.StringValue(creds.SessionToken),
+	}
+	// Expiration is a *int64 epoch-millis timestamp; SSO doesn't always populate it, in
+	// which case we leave p.expiration at its zero value so the next Retrieve re-fetches
+	// rather than caching credentials of unknown lifetime.
+	if creds.Expiration != nil {
+		p.expiration = time.Unix(0, *creds.Expiration*int64(time.Millisecond))
+	}
+
+	return p.credentials, nil
+}
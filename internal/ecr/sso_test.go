@@ -0,0 +1,63 @@
+package ecr
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSOProfileFromConfig_LegacyInlineKeys(t *testing.T) {
+	path := createProfile("tmp-sso-profile-legacy", "[profile my-sso-profile]\nsso_account_id = 123456789012\nsso_role_name = MyRole\nsso_start_url = https://my-sso-portal.awsapps.com/start\nsso_region = us-east-1\n")
+	defer removeProfile(t, path)
+
+	profile, ok, err := ssoProfileFromConfig("my-sso-profile")
+
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "https://my-sso-portal.awsapps.com/start", profile.StartURL)
+	require.Equal(t, "us-east-1", profile.Region)
+	require.Empty(t, profile.SessionName)
+	require.Equal(t, profile.StartURL, profile.tokenCacheKey())
+}
+
+func TestSSOProfileFromConfig_SSOSessionBlock(t *testing.T) {
+	path := createProfile("tmp-sso-profile-session", "[profile my-sso-profile]\nsso_session = my-session\nsso_account_id = 123456789012\nsso_role_name = MyRole\n\n[sso-session my-session]\nsso_start_url = https://my-sso-portal.awsapps.com/start\nsso_region = us-east-1\n")
+	defer removeProfile(t, path)
+
+	profile, ok, err := ssoProfileFromConfig("my-sso-profile")
+
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "my-session", profile.SessionName)
+	require.Equal(t, "https://my-sso-portal.awsapps.com/start", profile.StartURL)
+	// The sso-session token cache is keyed by the session name, not sso_start_url, so
+	// tokenCacheKey must prefer it whenever the profile names a session.
+	require.Equal(t, "my-session", profile.tokenCacheKey())
+}
+
+func TestReadCachedSSOToken_SSOSessionCacheHit(t *testing.T) {
+	home := t.TempDir()
+	restoreHome := setHomeDir(t, home)
+	defer restoreHome()
+
+	profile := ssoProfile{
+		StartURL:    "https://my-sso-portal.awsapps.com/start",
+		SessionName: "my-session",
+	}
+	// Written under sha1("my-session"), matching what `aws sso login --sso-session
+	// my-session` writes for the newer cache format.
+	writeSSOCacheToken(t, home, profile.tokenCacheKey(), "a-valid-token", time.Now().Add(time.Hour))
+
+	token, err := readCachedSSOToken(profile.tokenCacheKey())
+
+	require.NoError(t, err)
+	require.Equal(t, "a-valid-token", token.AccessToken)
+}
+
+func removeProfile(t *testing.T, path string) {
+	t.Helper()
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, os.Unsetenv("AWS_CONFIG_FILE"))
+}
@@ -0,0 +1,204 @@
+// Package sts wraps the subset of AWS STS behaviour trebuchet needs to turn an
+// assumed role into credentials the rest of the aws-sdk-go-v2 client chain can consume.
+package sts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// expiryWindow is how far ahead of the reported expiration we refresh credentials, so a
+// long-running push never trips over a token that expires mid-request.
+const expiryWindow = 5 * time.Minute
+
+// ErrMFARequired is returned when an AssumeRole call configured with an MFASerial is denied
+// in a way that indicates the caller must re-authenticate (e.g. a stale or wrong token
+// code). AWS doesn't expose a distinct error code for this — AccessDenied also covers
+// missing sts:AssumeRole permissions, a trust policy that doesn't allow the caller, and SCP
+// denies — so assumeRole only returns this for AccessDenied messages that mention MFA
+// specifically (see isMFAAccessDeniedMessage); anything else surfaces as the raw STS error.
+var ErrMFARequired = errors.New("MFA re-authentication required")
+
+// stsClient is the narrow seam over AWS STS that CredentialsProvider uses, mirroring the
+// ecr package's roleAssumer interface so tests can exercise assumeRole/
+// assumeRoleWithWebIdentity — including MFA prompt invocation and STS error paths — without
+// talking to AWS.
+type stsClient interface {
+	AssumeRole(input *sts.AssumeRoleInput) (*sts.Credentials, error)
+	AssumeRoleWithWebIdentity(input *sts.AssumeRoleWithWebIdentityInput) (*sts.Credentials, error)
+}
+
+// sdkSTSClient adapts *sts.Client's two-step Request/Send calling convention to stsClient.
+type sdkSTSClient struct {
+	client *sts.Client
+}
+
+func (s sdkSTSClient) AssumeRole(input *sts.AssumeRoleInput) (*sts.Credentials, error) {
+	resp, err := s.client.AssumeRoleRequest(input).Send(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return resp.Credentials, nil
+}
+
+func (s sdkSTSClient) AssumeRoleWithWebIdentity(input *sts.AssumeRoleWithWebIdentityInput) (*sts.Credentials, error) {
+	resp, err := s.client.AssumeRoleWithWebIdentityRequest(input).Send(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return resp.Credentials, nil
+}
+
+// CredentialsProvider is an aws.CredentialsProvider backed by a cached STS AssumeRole
+// session. It refreshes itself lazily the next time Retrieve is called once the cached
+// credentials fall within expiryWindow of expiring.
+type CredentialsProvider struct {
+	Client      stsClient
+	RoleArn     string
+	SessionName string
+
+	// WebIdentityTokenFilePath, when set, switches Retrieve to AssumeRoleWithWebIdentity.
+	// The file is re-read on every refresh rather than cached, since EKS/IRSA rotates it
+	// in place via a projected volume.
+	WebIdentityTokenFilePath string
+
+	// MFASerial and MFATokenProvider are set when the target role requires
+	// aws:MultiFactorAuthPresent. MFATokenProvider is called once per refresh to obtain the
+	// current token code (see stscreds.StdinTokenProvider for the expected shape).
+	MFASerial        string
+	MFATokenProvider func() (string, error)
+
+	// SessionDuration requests a non-default STS session lifetime; zero means let STS use
+	// its own default (1h).
+	SessionDuration time.Duration
+
+	expiration  time.Time
+	credentials aws.Credentials
+}
+
+// NewCredentialsProvider builds a CredentialsProvider that will assume arnRole using the
+// given STS client, identifying the session as sessionName.
+func NewCredentialsProvider(client *sts.Client, arnRole, sessionName string) *CredentialsProvider {
+	return &CredentialsProvider{
+		Client:      sdkSTSClient{client: client},
+		RoleArn:     arnRole,
+		SessionName: sessionName,
+	}
+}
+
+// NewWebIdentityCredentialsProvider builds a CredentialsProvider that will assume arnRole
+// via AssumeRoleWithWebIdentity using the given STS client, reading the OIDC token fresh
+// from tokenFilePath on every refresh.
+func NewWebIdentityCredentialsProvider(client *sts.Client, arnRole, sessionName, tokenFilePath string) *CredentialsProvider {
+	return &CredentialsProvider{
+		Client:                   sdkSTSClient{client: client},
+		RoleArn:                  arnRole,
+		SessionName:              sessionName,
+		WebIdentityTokenFilePath: tokenFilePath,
+	}
+}
+
+// Retrieve returns the cached credentials if they are still fresh, otherwise it refreshes
+// them via AssumeRole (or AssumeRoleWithWebIdentity, if WebIdentityTokenFilePath is set)
+// and caches the result.
+func (p *CredentialsProvider) Retrieve() (aws.Credentials, error) {
+	if p.credentials.AccessKeyID != "" && time.Now().Add(expiryWindow).Before(p.expiration) {
+		return p.credentials, nil
+	}
+
+	var (
+		creds *sts.Credentials
+		err   error
+	)
+
+	if p.WebIdentityTokenFilePath != "" {
+		creds, err = p.assumeRoleWithWebIdentity()
+	} else {
+		creds, err = p.assumeRole()
+	}
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	p.credentials = aws.Credentials{
+		AccessKeyID:     aws.StringValue(creds.AccessKeyId),
+		SecretAccessKey: aws.StringValue(creds.SecretAccessKey),
+		SessionToken:    aws.StringValue(creds.SessionToken),
+	}
+	p.expiration = *creds.Expiration
+
+	return p.credentials, nil
+}
+
+func (p *CredentialsProvider) assumeRole() (*sts.Credentials, error) {
+	input := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(p.RoleArn),
+		RoleSessionName: aws.String(p.SessionName),
+	}
+
+	if p.SessionDuration > 0 {
+		input.DurationSeconds = aws.Int64(int64(p.SessionDuration.Seconds()))
+	}
+
+	if p.MFASerial != "" {
+		if p.MFATokenProvider == nil {
+			return nil, errors.New("MFASerial set without an MFATokenProvider")
+		}
+
+		token, err := p.MFATokenProvider()
+		if err != nil {
+			return nil, err
+		}
+
+		input.SerialNumber = aws.String(p.MFASerial)
+		input.TokenCode = aws.String(token)
+	}
+
+	creds, err := p.Client.AssumeRole(input)
+	if err != nil {
+		if p.MFASerial != "" {
+			var awsErr awserr.Error
+			if errors.As(err, &awsErr) && awsErr.Code() == "AccessDenied" && isMFAAccessDeniedMessage(awsErr.Message()) {
+				return nil, ErrMFARequired
+			}
+		}
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+// isMFAAccessDeniedMessage reports whether an AccessDenied error's message indicates a
+// stale or wrong MFA token code specifically, as opposed to an unrelated AccessDenied cause
+// (missing sts:AssumeRole permission, a trust policy that doesn't allow the caller, an SCP
+// deny) that happens to share the same error code on an MFA-configured role. AWS doesn't
+// expose a distinct error code for this, only this substring in the message, so callers
+// should not assume every AccessDenied on such a role means "re-enter your MFA code".
+func isMFAAccessDeniedMessage(message string) bool {
+	lower := strings.ToLower(message)
+	return strings.Contains(lower, "mfa") || strings.Contains(lower, "multi-factor")
+}
+
+// assumeRoleWithWebIdentity reads the OIDC token from WebIdentityTokenFilePath fresh on
+// every call, since the projected volume EKS mounts it from rotates the file's contents
+// without changing its path.
+func (p *CredentialsProvider) assumeRoleWithWebIdentity() (*sts.Credentials, error) {
+	token, err := ioutil.ReadFile(p.WebIdentityTokenFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading web identity token file: %w", err)
+	}
+
+	return p.Client.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.RoleArn),
+		RoleSessionName:  aws.String(p.SessionName),
+		WebIdentityToken: aws.String(strings.TrimSpace(string(token))),
+	})
+}
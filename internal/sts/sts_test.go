@@ -0,0 +1,211 @@
+package sts
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSTSClient is a minimal stsClient stand-in so assumeRole/assumeRoleWithWebIdentity can
+// be exercised without talking to AWS.
+type fakeSTSClient struct {
+	assumeRoleCreds *sts.Credentials
+	assumeRoleErr   error
+	assumeRoleCalls int
+
+	webIdentityCreds *sts.Credentials
+	webIdentityErr   error
+}
+
+func (f *fakeSTSClient) AssumeRole(input *sts.AssumeRoleInput) (*sts.Credentials, error) {
+	f.assumeRoleCalls++
+	return f.assumeRoleCreds, f.assumeRoleErr
+}
+
+func (f *fakeSTSClient) AssumeRoleWithWebIdentity(input *sts.AssumeRoleWithWebIdentityInput) (*sts.Credentials, error) {
+	return f.webIdentityCreds, f.webIdentityErr
+}
+
+// fakeAWSError is a minimal awserr.Error stand-in, mirroring internal/ecr's fakeAWSError, so
+// tests can provoke specific STS error codes/messages without a real AWS SDK error.
+type fakeAWSError struct {
+	code    string
+	message string
+}
+
+func (e *fakeAWSError) Error() string   { return e.code }
+func (e *fakeAWSError) Code() string    { return e.code }
+func (e *fakeAWSError) Message() string { return e.message }
+func (e *fakeAWSError) OrigErr() error  { return nil }
+
+func TestCredentialsProvider_Retrieve_ReturnsCachedCredentialsBeforeExpiry(t *testing.T) {
+	cached := aws.Credentials{AccessKeyID: "cached-key"}
+	p := &CredentialsProvider{
+		credentials: cached,
+		expiration:  time.Now().Add(time.Hour),
+	}
+
+	result, err := p.Retrieve()
+
+	require.NoError(t, err)
+	require.Equal(t, cached, result)
+}
+
+func TestCredentialsProvider_Retrieve_RefreshesWhenWithinExpiryWindow(t *testing.T) {
+	p := &CredentialsProvider{
+		RoleArn:                  "arn:aws:iam::123456789012:role/irsa-role",
+		SessionName:              "trebuchet",
+		WebIdentityTokenFilePath: "/nonexistent/token",
+		credentials:              aws.Credentials{AccessKeyID: "stale-key"},
+		expiration:               time.Now().Add(expiryWindow - time.Minute),
+	}
+
+	_, err := p.Retrieve()
+
+	require.Error(t, err)
+}
+
+func TestCredentialsProvider_Retrieve_ReturnsErrorWhenWebIdentityTokenFileMissing(t *testing.T) {
+	p := &CredentialsProvider{
+		RoleArn:                  "arn:aws:iam::123456789012:role/irsa-role",
+		SessionName:              "trebuchet",
+		WebIdentityTokenFilePath: "/nonexistent/token",
+	}
+
+	_, err := p.Retrieve()
+
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "reading web identity token file")
+}
+
+func TestCredentialsProvider_Retrieve_DoesNotInvokeMFATokenProviderOnCacheHit(t *testing.T) {
+	tokenCalls := 0
+	p := &CredentialsProvider{
+		RoleArn:          "arn:aws:iam::123456789012:role/needs-mfa",
+		SessionName:      "trebuchet",
+		MFASerial:        "arn:aws:iam::123456789012:mfa/me",
+		MFATokenProvider: func() (string, error) { tokenCalls++; return "123456", nil },
+		credentials:      aws.Credentials{AccessKeyID: "cached-key"},
+		expiration:       time.Now().Add(time.Hour),
+	}
+
+	_, err := p.Retrieve()
+	require.NoError(t, err)
+	_, err = p.Retrieve()
+	require.NoError(t, err)
+
+	require.Equal(t, 0, tokenCalls)
+}
+
+func TestCredentialsProvider_AssumeRole_ReturnsErrorWhenMFASerialSetWithoutTokenProvider(t *testing.T) {
+	p := &CredentialsProvider{
+		RoleArn:     "arn:aws:iam::123456789012:role/needs-mfa",
+		SessionName: "trebuchet",
+		MFASerial:   "arn:aws:iam::123456789012:mfa/me",
+	}
+
+	_, err := p.assumeRole()
+
+	require.EqualError(t, err, "MFASerial set without an MFATokenProvider")
+}
+
+func TestCredentialsProvider_Retrieve_InvokesMFATokenProviderOnRefresh(t *testing.T) {
+	tokenCalls := 0
+	fake := &fakeSTSClient{assumeRoleCreds: &sts.Credentials{
+		AccessKeyId:     aws.String("assumed-key"),
+		SecretAccessKey: aws.String("assumed-secret"),
+		SessionToken:    aws.String("assumed-token"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}}
+	p := &CredentialsProvider{
+		Client:           fake,
+		RoleArn:          "arn:aws:iam::123456789012:role/needs-mfa",
+		SessionName:      "trebuchet",
+		MFASerial:        "arn:aws:iam::123456789012:mfa/me",
+		MFATokenProvider: func() (string, error) { tokenCalls++; return "123456", nil },
+	}
+
+	creds, err := p.Retrieve()
+
+	require.NoError(t, err)
+	require.Equal(t, "assumed-key", creds.AccessKeyID)
+	require.Equal(t, 1, tokenCalls)
+	require.Equal(t, 1, fake.assumeRoleCalls)
+}
+
+func TestCredentialsProvider_Retrieve_ReusesCachedCredentialsAcrossCalls(t *testing.T) {
+	fake := &fakeSTSClient{assumeRoleCreds: &sts.Credentials{
+		AccessKeyId:     aws.String("assumed-key"),
+		SecretAccessKey: aws.String("assumed-secret"),
+		SessionToken:    aws.String("assumed-token"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}}
+	p := &CredentialsProvider{
+		Client:           fake,
+		RoleArn:          "arn:aws:iam::123456789012:role/needs-mfa",
+		SessionName:      "trebuchet",
+		MFASerial:        "arn:aws:iam::123456789012:mfa/me",
+		MFATokenProvider: func() (string, error) { return "123456", nil },
+	}
+
+	_, err := p.Retrieve()
+	require.NoError(t, err)
+	_, err = p.Retrieve()
+	require.NoError(t, err)
+
+	require.Equal(t, 1, fake.assumeRoleCalls, "a cached, unexpired session should not re-invoke AssumeRole")
+}
+
+func TestCredentialsProvider_AssumeRole_PropagatesWrongTokenError(t *testing.T) {
+	fake := &fakeSTSClient{assumeRoleErr: &fakeAWSError{code: "AccessDenied", message: "MFA one time pass code is not valid"}}
+	p := &CredentialsProvider{
+		Client:           fake,
+		RoleArn:          "arn:aws:iam::123456789012:role/needs-mfa",
+		SessionName:      "trebuchet",
+		MFASerial:        "arn:aws:iam::123456789012:mfa/me",
+		MFATokenProvider: func() (string, error) { return "000000", nil },
+	}
+
+	_, err := p.assumeRole()
+
+	require.True(t, errors.Is(err, ErrMFARequired))
+}
+
+func TestCredentialsProvider_AssumeRole_DoesNotRelabelUnrelatedAccessDenied(t *testing.T) {
+	fake := &fakeSTSClient{assumeRoleErr: &fakeAWSError{code: "AccessDenied", message: "User is not authorized to perform: sts:AssumeRole"}}
+	p := &CredentialsProvider{
+		Client:           fake,
+		RoleArn:          "arn:aws:iam::123456789012:role/needs-mfa",
+		SessionName:      "trebuchet",
+		MFASerial:        "arn:aws:iam::123456789012:mfa/me",
+		MFATokenProvider: func() (string, error) { return "123456", nil },
+	}
+
+	_, err := p.assumeRole()
+
+	require.False(t, errors.Is(err, ErrMFARequired), "an AccessDenied unrelated to MFA should not be relabeled ErrMFARequired")
+	require.Equal(t, fake.assumeRoleErr, err)
+}
+
+func TestCredentialsProvider_Retrieve_PropagatesExpiredWebIdentityTokenError(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("a-jwt-token"), 0600))
+
+	fake := &fakeSTSClient{webIdentityErr: &fakeAWSError{code: "ExpiredTokenException", message: "web identity token is expired"}}
+	p := &CredentialsProvider{
+		Client:                   fake,
+		RoleArn:                  "arn:aws:iam::123456789012:role/irsa-role",
+		SessionName:              "trebuchet",
+		WebIdentityTokenFilePath: tokenFile,
+	}
+
+	_, err := p.Retrieve()
+
+	require.True(t, errors.Is(err, fake.webIdentityErr))
+}